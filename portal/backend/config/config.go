@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"time"
+
+	"go-auth-server/models"
 )
 
 type Config struct {
@@ -9,6 +12,24 @@ type Config struct {
 	JWTSecret   string
 	DatabaseURL string
 	CORSOrigins []string
+
+	UploadTTL             time.Duration // 上传中的文件超过此时间没有新分片则视为放弃
+	UploadJanitorInterval time.Duration // 过期上传清理间隔，0表示不自动清理
+	TrashRetention        time.Duration // 回收站中的文件保留时间，超过后由清理任务彻底删除，0表示不自动删除
+
+	MaxFileSize  int64 // 单个文件的大小上限（字节）
+	ChunkSize    int64 // 默认分片大小（字节）
+	MaxChunkSize int64 // 客户端可指定的最大分片大小（字节）
+
+	ExtractMaxEntries int   // 解压时允许的最大条目数
+	ExtractMaxSize    int64 // 解压后允许的最大总大小（字节）
+
+	FileTypes *FileTypePolicy
+
+	RoleQuotas map[string]int64 // 各角色的默认存储配额（字节），0表示不限制
+
+	SFTP          *models.SFTPConfig // 环境变量配置的默认SFTP目标
+	CredentialKey string             // 加密数据库中存储凭据的密钥，必须显式配置；修改后已保存的凭据将无法解密
 }
 
 func LoadConfig() *Config {
@@ -19,6 +40,21 @@ func LoadConfig() *Config {
 		CORSOrigins: []string{
 			getEnv("CORS_ORIGIN", "http://localhost:4200"),
 		},
+		UploadTTL:             getEnvAsDuration("UPLOAD_TTL", 24*time.Hour),
+		UploadJanitorInterval: getEnvAsDuration("UPLOAD_JANITOR_INTERVAL", time.Hour),
+		TrashRetention:        getEnvAsDuration("TRASH_RETENTION", 30*24*time.Hour),
+		MaxFileSize:           int64(getEnvAsInt("MAX_FILE_SIZE", 100*1024*1024)),
+		ChunkSize:             int64(getEnvAsInt("CHUNK_SIZE", 2*1024*1024)),
+		MaxChunkSize:          int64(getEnvAsInt("MAX_CHUNK_SIZE", 16*1024*1024)),
+		ExtractMaxEntries:     getEnvAsInt("EXTRACT_MAX_ENTRIES", 10000),
+		ExtractMaxSize:        int64(getEnvAsInt("EXTRACT_MAX_SIZE", 1024*1024*1024)),
+		FileTypes:             LoadFileTypePolicy(),
+		RoleQuotas: map[string]int64{
+			string(models.RoleAdmin): int64(getEnvAsInt("QUOTA_ADMIN", 0)),
+			string(models.RoleUser):  int64(getEnvAsInt("QUOTA_USER", 10*1024*1024*1024)),
+		},
+		SFTP:          LoadSFTPConfig(),
+		CredentialKey: getEnv("CREDENTIAL_KEY", ""),
 	}
 }
 
@@ -29,4 +65,12 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-
+// 获取环境变量并解析为时间间隔（如"24h"），如果不存在或解析失败则返回默认值
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+
+	"go-auth-server/models"
+)
+
+// 文件类型规则，扩展名为小写并以"."开头，"*"匹配所有扩展名
+type FileTypeRule struct {
+	Allow []string // 为nil表示不在此范围内限制
+	Deny  []string
+}
+
+// 文件类型策略。角色规则的Allow替代默认的Allow，存储类型规则的Allow进一步限制，
+// 任一适用规则的Deny命中即拒绝
+type FileTypePolicy struct {
+	Default  FileTypeRule
+	Roles    map[string]FileTypeRule
+	Storages map[string]FileTypeRule
+	// 文件内容与扩展名不符时的处理方式：reject拒绝上传，flag仅标记
+	MismatchAction string
+}
+
+const (
+	MismatchReject = "reject"
+	MismatchFlag   = "flag"
+)
+
+// 默认允许的文件类型
+var defaultAllowedExts = ".txt,.pdf,.doc,.docx,.xls,.xlsx,.ppt,.pptx," +
+	".jpg,.jpeg,.png,.gif,.bmp,.svg," +
+	".mp4,.avi,.mov,.wmv,.flv," +
+	".mp3,.wav,.flac,.aac," +
+	".zip,.rar,.7z,.tar,.gz"
+
+// 从环境变量加载文件类型策略，例如：
+// FILE_TYPES_ALLOW=.txt,.pdf      默认允许的类型
+// FILE_TYPES_DENY=.exe            默认禁止的类型
+// FILE_TYPES_ALLOW_ADMIN=*        按角色覆盖允许的类型
+// FILE_TYPES_DENY_SFTP=.mp4       按存储类型禁止的类型
+func LoadFileTypePolicy() *FileTypePolicy {
+	policy := &FileTypePolicy{
+		Default: FileTypeRule{
+			Allow: parseExtList(getEnv("FILE_TYPES_ALLOW", defaultAllowedExts)),
+			Deny:  parseExtList(getEnv("FILE_TYPES_DENY", "")),
+		},
+		Roles:          make(map[string]FileTypeRule),
+		Storages:       make(map[string]FileTypeRule),
+		MismatchAction: strings.ToLower(getEnv("FILE_TYPE_MISMATCH", MismatchReject)),
+	}
+
+	for _, role := range []models.UserRole{models.RoleAdmin, models.RoleUser} {
+		if rule, ok := loadFileTypeRule(string(role)); ok {
+			policy.Roles[string(role)] = rule
+		}
+	}
+	for _, storageType := range []models.StorageType{models.StorageLocal, models.StorageSFTP, models.StorageS3} {
+		if rule, ok := loadFileTypeRule(string(storageType)); ok {
+			policy.Storages[string(storageType)] = rule
+		}
+	}
+
+	return policy
+}
+
+func loadFileTypeRule(name string) (FileTypeRule, bool) {
+	suffix := "_" + strings.ToUpper(name)
+	rule := FileTypeRule{
+		Allow: parseExtList(getEnv("FILE_TYPES_ALLOW"+suffix, "")),
+		Deny:  parseExtList(getEnv("FILE_TYPES_DENY"+suffix, "")),
+	}
+	return rule, rule.Allow != nil || rule.Deny != nil
+}
+
+// 解析逗号分隔的扩展名列表，空字符串返回nil
+func parseExtList(value string) []string {
+	var exts []string
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if ext != "*" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
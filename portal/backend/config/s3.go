@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"go-auth-server/models"
+)
+
+// 从环境变量加载S3配置，未设置S3_ENDPOINT时S3存储不启用
+func LoadS3Config() *models.S3Config {
+	return &models.S3Config{
+		Endpoint:  getEnv("S3_ENDPOINT", ""),
+		AccessKey: getEnv("S3_ACCESS_KEY", ""),
+		SecretKey: getEnv("S3_SECRET_KEY", ""),
+		Bucket:    getEnv("S3_BUCKET", "uploads"),
+		Region:    getEnv("S3_REGION", ""),
+		UseSSL:    getEnvAsBool("S3_USE_SSL", false),
+	}
+}
+
+// 获取环境变量并转换为布尔值，如果不存在或转换失败则返回默认值
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// 验证S3配置
+func ValidateS3Config(config *models.S3Config) error {
+	if config.Endpoint == "" {
+		return fmt.Errorf("S3服务地址不能为空")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return fmt.Errorf("S3访问密钥不能为空")
+	}
+	if config.Bucket == "" {
+		return fmt.Errorf("S3存储桶不能为空")
+	}
+	return nil
+}
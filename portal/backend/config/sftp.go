@@ -3,21 +3,15 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"go-auth-server/models"
 )
 
-// SFTP配置结构
-type SFTPConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	BasePath string `json:"basePath"`
-}
-
-// 从环境变量加载SFTP配置
+// 从环境变量加载默认SFTP目标的配置，连接池参数同时作为数据库中命名目标的默认值
 func LoadSFTPConfig() *models.SFTPConfig {
 	config := &models.SFTPConfig{
 		Host:     getEnv("SFTP_HOST", "localhost"),
@@ -25,17 +19,47 @@ func LoadSFTPConfig() *models.SFTPConfig {
 		Username: getEnv("SFTP_USERNAME", "sftp_user"),
 		Password: getEnv("SFTP_PASSWORD", "sftp_password"),
 		BasePath: getEnv("SFTP_BASE_PATH", "/uploads"),
+
+		PrivateKey:            getEnv("SFTP_PRIVATE_KEY", ""),
+		PrivateKeyPath:        getEnv("SFTP_PRIVATE_KEY_PATH", ""),
+		PrivateKeyPassphrase:  getEnv("SFTP_PRIVATE_KEY_PASSPHRASE", ""),
+		UseAgent:              getEnvAsBool("SFTP_USE_AGENT", false),
+		KnownHostsPath:        getEnv("SFTP_KNOWN_HOSTS", defaultKnownHostsPath()),
+		HostKeyFingerprints:   parseList(getEnv("SFTP_HOST_KEY_FINGERPRINTS", "")),
+		InsecureIgnoreHostKey: getEnvAsBool("SFTP_INSECURE_IGNORE_HOST_KEY", false),
+		DisableDirectWrite:    getEnvAsBool("SFTP_DISABLE_DIRECT_WRITE", false),
+
+		MaxConns:            getEnvAsInt("SFTP_POOL_MAX_CONNS", 8),
+		IdleTimeout:         getEnvAsDuration("SFTP_POOL_IDLE_TIMEOUT", 5*time.Minute),
+		HealthCheckInterval: getEnvAsDuration("SFTP_POOL_HEALTH_CHECK_INTERVAL", 30*time.Second),
+		WaitTimeout:         getEnvAsDuration("SFTP_POOL_WAIT_TIMEOUT", 30*time.Second),
 	}
 
 	return config
 }
 
-// 获取环境变量，如果不存在则返回默认值
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// 默认使用当前用户的known_hosts，文件不存在时返回空
+func defaultKnownHostsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
-	return defaultValue
+	path := filepath.Join(home, ".ssh", "known_hosts")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// 解析逗号分隔的列表，忽略空项
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // 获取环境变量并转换为整数，如果不存在或转换失败则返回默认值
@@ -59,11 +83,20 @@ func ValidateSFTPConfig(config *models.SFTPConfig) error {
 	if config.Username == "" {
 		return fmt.Errorf("SFTP用户名不能为空")
 	}
-	if config.Password == "" {
-		return fmt.Errorf("SFTP密码不能为空")
+	if config.Password == "" && config.PrivateKey == "" && config.PrivateKeyPath == "" && !config.UseAgent {
+		return fmt.Errorf("SFTP需要配置密码、私钥或ssh-agent中的至少一种认证方式")
+	}
+	if config.PrivateKey != "" && config.PrivateKeyPath != "" {
+		return fmt.Errorf("SFTP私钥内容和私钥路径只能配置一个")
+	}
+	if len(config.HostKeyFingerprints) == 0 && config.KnownHostsPath == "" && !config.InsecureIgnoreHostKey {
+		return fmt.Errorf("SFTP需要配置known_hosts文件或主机密钥指纹")
 	}
 	if config.BasePath == "" {
 		return fmt.Errorf("SFTP基础路径不能为空")
 	}
+	if config.MaxConns <= 0 {
+		return fmt.Errorf("SFTP连接池最大连接数必须大于0")
+	}
 	return nil
 }
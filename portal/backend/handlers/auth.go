@@ -203,6 +203,45 @@ func (h *AuthHandler) GetUserList(c *gin.Context) {
 	})
 }
 
+// 更新用户存储配额（仅管理员）
+func (h *AuthHandler) UpdateUserQuota(c *gin.Context) {
+	var req models.UpdateUserQuotaRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	operatorRole, exists := c.Get("role")
+	if !exists || operatorRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以修改用户配额",
+			Code:    403,
+		})
+		return
+	}
+
+	if err := h.authService.UpdateUserQuota(req.UserID, req.Quota, req.Unlimited); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "配额更新成功",
+		Code:    200,
+	})
+}
+
 // 更新用户角色
 func (h *AuthHandler) UpdateUserRole(c *gin.Context) {
 	var req models.UpdateUserRoleRequest
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"go-auth-server/models"
 	"go-auth-server/services"
+	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -40,7 +47,7 @@ func (h *FileHandler) CreateFile(c *gin.Context) {
 	}
 
 	// 验证文件类型
-	if err := h.fileService.ValidateFileType(req.FileName); err != nil {
+	if err := h.fileService.ValidateFileType(c.GetString("role"), req.StorageType, req.FileName); err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
 			Message: err.Error(),
@@ -69,9 +76,14 @@ func (h *FileHandler) CreateFile(c *gin.Context) {
 		return
 	}
 
+	message := "文件创建成功"
+	if file.Status == models.FileStatusCompleted {
+		message = "文件秒传成功"
+	}
+
 	c.JSON(http.StatusOK, models.ApiResponse{
 		Success: true,
-		Message: "文件创建成功",
+		Message: message,
 		Data:    file,
 		Code:    200,
 	})
@@ -99,7 +111,80 @@ func (h *FileHandler) UploadChunk(c *gin.Context) {
 		return
 	}
 
-	err := h.fileService.UploadChunk(req.FileID, req.ChunkIndex, req.ChunkData)
+	err := h.fileService.UploadChunk(userID.(uint), req.FileID, req.ChunkIndex, req.ChunkData, req.ChunkChecksum)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "分片上传成功",
+		Code:    200,
+	})
+}
+
+// 上传二进制文件分片，请求体为application/octet-stream或multipart/form-data中的chunk字段，
+// 可通过X-Chunk-Checksum和X-Chunk-Checksum-Algorithm头携带分片校验和
+func (h *FileHandler) UploadChunkBinary(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("fileId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	chunkIndex, err := strconv.Atoi(c.Param("chunkIndex"))
+	if err != nil || chunkIndex < 0 {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的分片序号",
+			Code:    400,
+		})
+		return
+	}
+
+	// 单个分片不能超过最大分片大小，额外预留multipart表单的开销
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.fileService.GetMaxChunkSize()+64*1024)
+
+	var data io.Reader = c.Request.Body
+	if c.ContentType() == "multipart/form-data" {
+		part, err := chunkFormPart(c.Request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ApiResponse{
+				Success: false,
+				Message: "请求参数错误: " + err.Error(),
+				Code:    400,
+			})
+			return
+		}
+		defer part.Close()
+		data = part
+	}
+
+	checksum := models.ChunkChecksum{
+		Algorithm: c.GetHeader("X-Chunk-Checksum-Algorithm"),
+		Value:     c.GetHeader("X-Chunk-Checksum"),
+	}
+
+	err = h.fileService.UploadChunkStream(userID.(uint), uint(fileID), chunkIndex, data, checksum)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
@@ -116,6 +201,68 @@ func (h *FileHandler) UploadChunk(c *gin.Context) {
 	})
 }
 
+// 从multipart请求中找到chunk字段，逐个读取part而不缓存整个请求体
+func chunkFormPart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("缺少chunk字段")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "chunk" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// 查找可续传的上传记录
+func (h *FileHandler) FindResumableUpload(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	var req models.ResumeUploadRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	resume, err := h.fileService.FindResumableUpload(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    404,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    resume,
+		Code:    200,
+	})
+}
+
 // 获取文件列表
 func (h *FileHandler) GetFileList(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -236,6 +383,124 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 	})
 }
 
+// 获取回收站列表
+func (h *FileHandler) GetTrashList(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	var req models.TrashListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	fileList, err := h.fileService.GetTrashList(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    fileList,
+		Code:    200,
+	})
+}
+
+// 从回收站恢复文件
+func (h *FileHandler) RestoreFile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	file, err := h.fileService.RestoreFile(userID.(uint), uint(fileID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "文件恢复成功",
+		Data:    file,
+		Code:    200,
+	})
+}
+
+// 彻底删除回收站中的文件
+func (h *FileHandler) PurgeFile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	if err := h.fileService.PurgeFile(userID.(uint), uint(fileID)); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "文件已彻底删除",
+		Code:    200,
+	})
+}
+
 // 重命名文件
 func (h *FileHandler) RenameFile(c *gin.Context) {
 	userID, exists := c.Get("userID")
@@ -314,8 +579,8 @@ func (h *FileHandler) MoveFile(c *gin.Context) {
 	})
 }
 
-// 获取上传进度
-func (h *FileHandler) GetUploadProgress(c *gin.Context) {
+// 复制文件或文件夹
+func (h *FileHandler) CopyFile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.ApiResponse{
@@ -326,18 +591,17 @@ func (h *FileHandler) GetUploadProgress(c *gin.Context) {
 		return
 	}
 
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
-	if err != nil {
+	var req models.CopyFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
-			Message: "无效的文件ID",
+			Message: "请求参数错误: " + err.Error(),
 			Code:    400,
 		})
 		return
 	}
 
-	progress, err := h.fileService.GetUploadProgress(uint(fileID))
+	file, err := h.fileService.CopyFile(userID.(uint), &req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
@@ -349,14 +613,14 @@ func (h *FileHandler) GetUploadProgress(c *gin.Context) {
 
 	c.JSON(http.StatusOK, models.ApiResponse{
 		Success: true,
-		Message: "获取成功",
-		Data:    progress,
+		Message: "文件复制成功",
+		Data:    file,
 		Code:    200,
 	})
 }
 
-// 下载文件
-func (h *FileHandler) DownloadFile(c *gin.Context) {
+// 批量删除
+func (h *FileHandler) BatchDeleteFiles(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.ApiResponse{
@@ -367,28 +631,36 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 		return
 	}
 
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
-	if err != nil {
+	var req models.BatchDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
-			Message: "无效的文件ID",
+			Message: "请求参数错误: " + err.Error(),
 			Code:    400,
 		})
 		return
 	}
 
-	// 这里需要实现文件下载逻辑
-	// 根据存储类型从不同位置读取文件
+	result, err := h.fileService.BatchDeleteFiles(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Message: "文件下载功能待实现",
+		Success: result.Failed == 0,
+		Message: batchMessage("删除", result),
+		Data:    result,
 		Code:    200,
 	})
 }
 
-// 获取文件信息
-func (h *FileHandler) GetFileInfo(c *gin.Context) {
+// 批量移动
+func (h *FileHandler) BatchMoveFiles(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.ApiResponse{
@@ -399,27 +671,36 @@ func (h *FileHandler) GetFileInfo(c *gin.Context) {
 		return
 	}
 
-	fileIDStr := c.Param("id")
-	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
-	if err != nil {
+	var req models.BatchTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
-			Message: "无效的文件ID",
+			Message: "请求参数错误: " + err.Error(),
 			Code:    400,
 		})
 		return
 	}
 
-	// 这里需要实现获取文件信息的逻辑
+	result, err := h.fileService.BatchMoveFiles(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, models.ApiResponse{
-		Success: true,
-		Message: "获取文件信息功能待实现",
+		Success: result.Failed == 0,
+		Message: batchMessage("移动", result),
+		Data:    result,
 		Code:    200,
 	})
 }
 
-// 测试SFTP连接
-func (h *FileHandler) TestSFTPConnection(c *gin.Context) {
+// 批量复制
+func (h *FileHandler) BatchCopyFiles(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, models.ApiResponse{
@@ -430,18 +711,590 @@ func (h *FileHandler) TestSFTPConnection(c *gin.Context) {
 		return
 	}
 
-	// 验证用户权限（只有管理员可以测试SFTP连接）
-	userRole, exists := c.Get("role")
-	if !exists || userRole.(string) != string(models.RoleAdmin) {
-		c.JSON(http.StatusForbidden, models.ApiResponse{
+	var req models.BatchTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
-			Message: "权限不足：只有管理员可以测试SFTP连接",
-			Code:    403,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	result, err := h.fileService.BatchCopyFiles(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: result.Failed == 0,
+		Message: batchMessage("复制", result),
+		Data:    result,
+		Code:    200,
+	})
+}
+
+// 批量操作的提示信息
+func batchMessage(action string, result *models.BatchResult) string {
+	if result.Failed == 0 {
+		return fmt.Sprintf("%s成功", action)
+	}
+	return fmt.Sprintf("%s完成：成功 %d 项，失败 %d 项", action, result.Succeeded, result.Failed)
+}
+
+// 获取上传限制
+func (h *FileHandler) GetUploadLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    h.fileService.GetUploadLimits(c.GetString("role")),
+		Code:    200,
+	})
+}
+
+// 获取当前用户的空间使用情况
+func (h *FileHandler) GetUsage(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	usage, err := h.fileService.GetUsage(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    usage,
+		Code:    200,
+	})
+}
+
+// 获取指定用户的空间使用情况（仅管理员）
+func (h *FileHandler) GetUserUsage(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以查看其他用户的空间使用情况",
+			Code:    403,
+		})
+		return
+	}
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的用户ID",
+			Code:    400,
+		})
+		return
+	}
+
+	usage, err := h.fileService.GetUsage(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    usage,
+		Code:    200,
+	})
+}
+
+// 获取上传进度
+func (h *FileHandler) GetUploadProgress(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileIDStr := c.Param("id")
+	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	progress, err := h.fileService.GetUploadProgress(userID.(uint), uint(fileID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    progress,
+		Code:    200,
+	})
+}
+
+// 获取事件流票据，EventSource连接时通过ticket查询参数认证
+func (h *FileHandler) IssueEventTicket(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	ticket, err := h.fileService.IssueEventTicket(userID.(uint), c.GetString("username"), c.GetString("role"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    ticket,
+		Code:    200,
+	})
+}
+
+// 上传事件流（SSE），推送当前用户所有上传的分片、合并和完成事件，替代轮询上传进度
+func (h *FileHandler) StreamEvents(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	events, unsubscribe := h.fileService.SubscribeEvents(userID.(uint))
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	// 先推送进行中的上传，订阅之后才查询，避免漏掉期间发生的事件
+	for _, event := range h.fileService.PendingUploadEvents(userID.(uint)) {
+		c.SSEvent(string(event.Type), event)
+	}
+	c.Writer.Flush()
+
+	// 定期发送注释行保持连接，防止代理因空闲断开
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event := <-events:
+			c.SSEvent(string(event.Type), event)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		}
+	})
+}
+
+// 重新合并失败的文件
+func (h *FileHandler) RetryMerge(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileIDStr := c.Param("id")
+	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	err = h.fileService.RetryMerge(userID.(uint), uint(fileID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "已重新开始合并",
+		Code:    200,
+	})
+}
+
+// 下载文件
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileIDStr := c.Param("id")
+	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	file, reader, err := h.fileService.OpenFileForDownload(userID.(uint), uint(fileID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+	defer reader.Close()
+
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", contentDisposition(file.OriginalName))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if file.Hash != "" {
+		c.Header("ETag", `"`+file.Hash+`"`)
+	}
+
+	// ServeContent负责处理Range、If-Range、If-None-Match和If-Modified-Since，
+	// 分段读取通过Seek实现，SFTP文件会直接在远端定位而不会读取整个文件
+	http.ServeContent(c.Writer, c.Request, file.OriginalName, file.UpdatedAt, reader)
+}
+
+// 将文件夹或多个文件打包为ZIP下载
+func (h *FileHandler) DownloadZip(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	var req models.ZipDownloadRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	archive, err := h.fileService.PrepareZipDownload(userID.(uint), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", contentDisposition(archive.Name))
+	c.Status(http.StatusOK)
+
+	// 响应头已发送，出错时只能记录日志，客户端收到的ZIP缺少目录区会被识别为损坏
+	if err := h.fileService.WriteZip(c.Writer, archive); err != nil {
+		log.Printf("打包下载失败: %v", err)
+	}
+}
+
+// 解压压缩包，解压在后台执行，通过返回的任务查询进度
+func (h *FileHandler) ExtractArchive(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	job, err := h.fileService.StartExtract(userID.(uint), uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "解压任务已创建",
+		Data:    job,
+		Code:    200,
+	})
+}
+
+// 获取解压任务进度
+func (h *FileHandler) GetExtractJob(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的任务ID",
+			Code:    400,
+		})
+		return
+	}
+
+	job, err := h.fileService.GetExtractJob(userID.(uint), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    404,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    job,
+		Code:    200,
+	})
+}
+
+// 生成Content-Disposition头，非ASCII文件名按RFC 5987编码
+func contentDisposition(fileName string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+
+	var encoded strings.Builder
+	for _, b := range []byte(fileName) {
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, encoded.String())
+}
+
+// 获取文件信息
+func (h *FileHandler) GetFileInfo(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	fileIDStr := c.Param("id")
+	fileID, err := strconv.ParseUint(fileIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的文件ID",
+			Code:    400,
+		})
+		return
+	}
+
+	info, err := h.fileService.GetFileInfo(userID.(uint), uint(fileID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    info,
+		Code:    200,
+	})
+}
+
+// 清理过期上传（仅管理员）
+func (h *FileHandler) CleanupStaleUploads(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以清理过期上传",
+			Code:    403,
+		})
+		return
+	}
+
+	result := h.fileService.CleanupStaleUploads()
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "清理完成",
+		Data:    result,
+		Code:    200,
+	})
+}
+
+// 获取SFTP连接池统计（管理员）
+func (h *FileHandler) GetSFTPPoolStats(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以查看连接池状态",
+			Code:    403,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    h.fileService.GetSFTPPoolStats(),
+		Code:    200,
+	})
+}
+
+// 测试SFTP连接
+func (h *FileHandler) TestSFTPConnection(c *gin.Context) {
+	_, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "未授权",
+			Code:    401,
+		})
+		return
+	}
+
+	// 验证用户权限（只有管理员可以测试SFTP连接）
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以测试SFTP连接",
+			Code:    403,
+		})
+		return
+	}
+
+	// 请求体可省略，此时测试默认目标
+	var req models.SFTPTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
 		})
 		return
 	}
 
-	err := h.fileService.TestSFTPConnection()
+	authMethod, err := h.fileService.TestSFTPConnection(&req)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ApiResponse{
 			Success: false,
@@ -454,6 +1307,7 @@ func (h *FileHandler) TestSFTPConnection(c *gin.Context) {
 	c.JSON(http.StatusOK, models.ApiResponse{
 		Success: true,
 		Message: "SFTP连接测试成功",
+		Data:    models.SFTPTestResult{AuthMethod: authMethod},
 		Code:    200,
 	})
 }
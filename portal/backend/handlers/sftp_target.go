@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"go-auth-server/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取SFTP目标列表
+func (h *FileHandler) ListSFTPTargets(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以管理SFTP目标",
+			Code:    403,
+		})
+		return
+	}
+
+	targets, err := h.fileService.ListSFTPTargets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    500,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "获取成功",
+		Data:    targets,
+		Code:    200,
+	})
+}
+
+// 创建SFTP目标
+func (h *FileHandler) CreateSFTPTarget(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以管理SFTP目标",
+			Code:    403,
+		})
+		return
+	}
+
+	var req models.SFTPTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	target, err := h.fileService.CreateSFTPTarget(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "SFTP目标创建成功",
+		Data:    target,
+		Code:    200,
+	})
+}
+
+// 更新SFTP目标
+func (h *FileHandler) UpdateSFTPTarget(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以管理SFTP目标",
+			Code:    403,
+		})
+		return
+	}
+
+	targetID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的目标ID",
+			Code:    400,
+		})
+		return
+	}
+
+	var req models.SFTPTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "请求参数错误: " + err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	target, err := h.fileService.UpdateSFTPTarget(uint(targetID), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "SFTP目标更新成功",
+		Data:    target,
+		Code:    200,
+	})
+}
+
+// 删除SFTP目标
+func (h *FileHandler) DeleteSFTPTarget(c *gin.Context) {
+	userRole, exists := c.Get("role")
+	if !exists || userRole.(string) != string(models.RoleAdmin) {
+		c.JSON(http.StatusForbidden, models.ApiResponse{
+			Success: false,
+			Message: "权限不足：只有管理员可以管理SFTP目标",
+			Code:    403,
+		})
+		return
+	}
+
+	targetID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: "无效的目标ID",
+			Code:    400,
+		})
+		return
+	}
+
+	if err := h.fileService.DeleteSFTPTarget(uint(targetID)); err != nil {
+		c.JSON(http.StatusBadRequest, models.ApiResponse{
+			Success: false,
+			Message: err.Error(),
+			Code:    400,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ApiResponse{
+		Success: true,
+		Message: "SFTP目标已删除",
+		Code:    200,
+	})
+}
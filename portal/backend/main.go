@@ -8,6 +8,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"go-auth-server/config"
 	"go-auth-server/handlers"
 	"go-auth-server/middleware"
 	"go-auth-server/models"
@@ -15,22 +16,49 @@ import (
 )
 
 func main() {
+	cfg := config.LoadConfig()
+
 	// 初始化数据库
-	db, err := gorm.Open(sqlite.Open("auth.db"), &gorm.Config{})
+	// 并发上传分片时会有多个写事务，设置busy_timeout等待锁释放而不是直接报错
+	db, err := gorm.Open(sqlite.Open("auth.db?_busy_timeout=5000"), &gorm.Config{})
 	if err != nil {
 		log.Fatal("数据库连接失败:", err)
 	}
 
 	// 自动迁移
-	db.AutoMigrate(&models.User{}, &models.File{})
+	db.AutoMigrate(&models.User{}, &models.File{}, &models.FileChunk{}, &models.ExtractJob{}, &models.SFTPTarget{})
 
 	// 创建默认管理员用户
 	createDefaultAdmin(db)
 
+	// 初始化存储后端
+	storages := services.NewStorageRegistry()
+	storages.Register(models.StorageLocal, services.NewLocalStorage("uploads"))
+	if err := config.ValidateSFTPConfig(cfg.SFTP); err != nil {
+		log.Println("SFTP配置无效，默认SFTP存储不可用:", err)
+	} else {
+		storages.Register(models.StorageSFTP, services.NewSFTPService(cfg.SFTP))
+	}
+	if cfg.CredentialKey == "" {
+		log.Println("未设置CREDENTIAL_KEY，无法创建或使用数据库中的SFTP目标")
+	}
+	s3Config := config.LoadS3Config()
+	if err := config.ValidateS3Config(s3Config); err == nil {
+		s3Storage, err := services.NewS3Storage(s3Config)
+		if err != nil {
+			log.Println("S3存储初始化失败:", err)
+		} else {
+			storages.Register(models.StorageS3, s3Storage)
+		}
+	}
+
 	// 初始化服务
 	authService := services.NewAuthService(db)
 	authHandler := handlers.NewAuthHandler(authService)
-	fileService := services.NewFileService(db)
+	fileService := services.NewFileService(db, storages, cfg)
+	fileService.StartMergeWorkers(4)
+	fileService.StartExtractWorkers(2)
+	fileService.StartJanitor()
 	fileHandler := handlers.NewFileHandler(fileService)
 
 	// 初始化Gin
@@ -40,8 +68,8 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:4200"}, // Angular开发服务器地址
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Range", "If-Range", "If-None-Match", "If-Modified-Since", "X-Chunk-Checksum", "X-Chunk-Checksum-Algorithm"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag", "Last-Modified"},
 		AllowCredentials: true,
 	}))
 
@@ -64,6 +92,8 @@ func main() {
 		{
 			users.GET("/list", authHandler.GetUserList)
 			users.PUT("/role", authHandler.UpdateUserRole)
+			users.PUT("/quota", authHandler.UpdateUserQuota)
+			users.GET("/:id/usage", fileHandler.GetUserUsage)
 		}
 
 		// 文件管理路由
@@ -72,16 +102,48 @@ func main() {
 		{
 			files.POST("/create", fileHandler.CreateFile)
 			files.POST("/chunk", fileHandler.UploadChunk)
+			files.POST("/chunk/:fileId/:chunkIndex", fileHandler.UploadChunkBinary)
 			files.GET("/list", fileHandler.GetFileList)
+			files.GET("/usage", fileHandler.GetUsage)
+			files.GET("/limits", fileHandler.GetUploadLimits)
 			files.POST("/folder", fileHandler.CreateFolder)
 			files.DELETE("/:id", fileHandler.DeleteFile)
+			files.GET("/trash", fileHandler.GetTrashList)
+			files.POST("/trash/:id/restore", fileHandler.RestoreFile)
+			files.DELETE("/trash/:id", fileHandler.PurgeFile)
 			files.PUT("/rename", fileHandler.RenameFile)
 			files.PUT("/move", fileHandler.MoveFile)
+			files.POST("/copy", fileHandler.CopyFile)
+			files.POST("/batch/delete", fileHandler.BatchDeleteFiles)
+			files.POST("/batch/move", fileHandler.BatchMoveFiles)
+			files.POST("/batch/copy", fileHandler.BatchCopyFiles)
 			files.GET("/progress/:id", fileHandler.GetUploadProgress)
+			files.POST("/events/ticket", fileHandler.IssueEventTicket)
+			files.POST("/merge/:id", fileHandler.RetryMerge)
+			files.GET("/resume", fileHandler.FindResumableUpload)
 			files.GET("/download/:id", fileHandler.DownloadFile)
+			files.HEAD("/download/:id", fileHandler.DownloadFile)
+			files.GET("/download-zip", fileHandler.DownloadZip)
+			files.POST("/extract/:id", fileHandler.ExtractArchive)
+			files.GET("/extract-jobs/:id", fileHandler.GetExtractJob)
 			files.GET("/info/:id", fileHandler.GetFileInfo)
 			files.POST("/test-sftp", fileHandler.TestSFTPConnection)
+			files.POST("/admin/cleanup-uploads", fileHandler.CleanupStaleUploads)
+			files.GET("/admin/sftp-pool", fileHandler.GetSFTPPoolStats)
 		}
+
+		// SFTP目标管理路由（管理员）
+		sftpTargets := api.Group("/sftp-targets")
+		sftpTargets.Use(middleware.AuthMiddleware())
+		{
+			sftpTargets.GET("/list", fileHandler.ListSFTPTargets)
+			sftpTargets.POST("/create", fileHandler.CreateSFTPTarget)
+			sftpTargets.PUT("/:id", fileHandler.UpdateSFTPTarget)
+			sftpTargets.DELETE("/:id", fileHandler.DeleteSFTPTarget)
+		}
+
+		// 上传事件流，EventSource无法设置请求头，允许通过一次性票据认证
+		api.GET("/files/events", middleware.EventStreamAuthMiddleware(), fileHandler.StreamEvents)
 	}
 
 	// 启动服务器
@@ -35,25 +35,65 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token := tokenParts[1]
-		claims, err := utils.ParseToken(token)
-		if err != nil {
+		authenticate(c, tokenParts[1])
+	}
+}
+
+// EventStreamAuthMiddleware 事件流认证：浏览器 EventSource 无法设置请求头，
+// 因此在缺少 Authorization 时允许通过 ticket 查询参数传递一次性票据。
+// 不接受查询参数中的 JWT，避免长期有效的令牌出现在访问日志中
+func EventStreamAuthMiddleware() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			auth(c)
+			return
+		}
+
+		ticket := c.Query("ticket")
+		if ticket == "" {
 			c.JSON(http.StatusUnauthorized, models.ApiResponse{
 				Success: false,
-				Message: "无效的认证令牌",
+				Message: "缺少认证令牌",
 				Code:    401,
 			})
 			c.Abort()
 			return
 		}
 
-		// 将用户信息存储到上下文中
-		c.Set("userID", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
+		claims, err := utils.RedeemStreamTicket(ticket)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, models.ApiResponse{
+				Success: false,
+				Message: "无效或已过期的事件流票据",
+				Code:    401,
+			})
+			c.Abort()
+			return
+		}
+		setClaims(c, claims)
+	}
+}
 
-		c.Next()
+func authenticate(c *gin.Context, token string) {
+	claims, err := utils.ParseToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.ApiResponse{
+			Success: false,
+			Message: "无效的认证令牌",
+			Code:    401,
+		})
+		c.Abort()
+		return
 	}
+	setClaims(c, claims)
 }
 
+// 将用户信息存储到上下文中
+func setClaims(c *gin.Context, claims *utils.Claims) {
+	c.Set("userID", claims.UserID)
+	c.Set("username", claims.Username)
+	c.Set("role", claims.Role)
 
+	c.Next()
+}
@@ -8,6 +8,7 @@ type StorageType string
 const (
 	StorageLocal StorageType = "local" // 本地存储
 	StorageSFTP  StorageType = "sftp"  // SFTP存储
+	StorageS3    StorageType = "s3"    // S3兼容对象存储
 )
 
 // 文件状态
@@ -15,6 +16,7 @@ type FileStatus string
 
 const (
 	FileStatusUploading FileStatus = "uploading" // 上传中
+	FileStatusMerging   FileStatus = "merging"   // 合并中
 	FileStatusCompleted FileStatus = "completed" // 已完成
 	FileStatusFailed    FileStatus = "failed"    // 失败
 	FileStatusDeleted   FileStatus = "deleted"   // 已删除
@@ -30,17 +32,58 @@ type File struct {
 	FileSize       int64       `json:"fileSize" gorm:"column:file_size;not null"`
 	MimeType       string      `json:"mimeType" gorm:"column:mime_type"`
 	StorageType    StorageType `json:"storageType" gorm:"column:storage_type;default:'local'"`
+	SFTPTargetID   *uint       `json:"sftpTargetId,omitempty" gorm:"column:sftp_target_id;index"` // 所在的SFTP目标，nil表示环境变量配置的默认目标
 	Status         FileStatus  `json:"status" gorm:"default:'uploading'"`
 	ParentID       *uint       `json:"parentId" gorm:"column:parent_id;index"` // 父文件夹ID，nil表示根目录
 	IsFolder       bool        `json:"isFolder" gorm:"column:is_folder;default:false"`
-	Hash           string      `json:"hash"`                                         // 文件MD5哈希，用于去重和断点续传
-	ChunkCount     int         `json:"chunkCount" gorm:"column:chunk_count"`         // 分片数量
-	UploadedChunks string      `json:"uploadedChunks" gorm:"column:uploaded_chunks"` // 已上传的分片，JSON格式存储
+	Hash           string      `json:"hash" gorm:"index"`                                      // 文件MD5哈希，用于去重和断点续传
+	ChunkCount     int         `json:"chunkCount" gorm:"column:chunk_count"`                   // 分片数量
+	ChunkSize      int64       `json:"chunkSize" gorm:"column:chunk_size"`                     // 分片大小，除最后一个分片外每个分片都必须是这个大小
+	UploadedChunks string      `json:"uploadedChunks" gorm:"column:uploaded_chunks"`           // 已上传的分片，JSON格式存储
+	UploadID       string      `json:"-" gorm:"column:upload_id"`                              // 存储后端的分片上传会话ID，如S3 multipart upload
+	FailReason     string      `json:"failReason,omitempty" gorm:"column:fail_reason"`         // 上传失败原因
+	TypeMismatch   bool        `json:"typeMismatch" gorm:"column:type_mismatch;default:false"` // 文件内容与扩展名不符
 	CreatedAt      time.Time   `json:"createdAt"`
 	UpdatedAt      time.Time   `json:"updatedAt"`
 	DeletedAt      *time.Time  `json:"deletedAt,omitempty" gorm:"index"`
 }
 
+// 已上传的分片，file_id和chunk_index唯一，并发上传时由数据库保证不重复记录
+type FileChunk struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	FileID     uint      `json:"fileId" gorm:"column:file_id;not null;uniqueIndex:idx_file_chunk"`
+	ChunkIndex int       `json:"chunkIndex" gorm:"column:chunk_index;not null;uniqueIndex:idx_file_chunk"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// 解压任务状态
+type ExtractStatus string
+
+const (
+	ExtractStatusPending   ExtractStatus = "pending"   // 等待中
+	ExtractStatusRunning   ExtractStatus = "running"   // 解压中
+	ExtractStatusCompleted ExtractStatus = "completed" // 已完成
+	ExtractStatusFailed    ExtractStatus = "failed"    // 失败
+)
+
+// 压缩包解压任务，解压结果放在FolderID对应的新文件夹中
+type ExtractJob struct {
+	ID               uint          `json:"id" gorm:"primaryKey"`
+	UserID           uint          `json:"userId" gorm:"column:user_id;not null;index"`
+	FileID           uint          `json:"fileId" gorm:"column:file_id;not null"`     // 压缩包
+	FolderID         uint          `json:"folderId" gorm:"column:folder_id;not null"` // 解压目标文件夹
+	Status           ExtractStatus `json:"status" gorm:"default:'pending'"`
+	TotalEntries     int           `json:"totalEntries" gorm:"column:total_entries"` // tar格式事先无法得知条目数，为0
+	ProcessedEntries int           `json:"processedEntries" gorm:"column:processed_entries"`
+	SkippedEntries   int           `json:"skippedEntries" gorm:"column:skipped_entries"` // 不支持的文件类型、链接等被跳过的条目
+	ExtractedBytes   int64         `json:"extractedBytes" gorm:"column:extracted_bytes"`
+	Progress         float64       `json:"progress"` // 0-100
+	Error            string        `json:"error,omitempty"`
+	CreatedAt        time.Time     `json:"createdAt"`
+	UpdatedAt        time.Time     `json:"updatedAt"`
+}
+
 // 文件上传请求
 type FileUploadRequest struct {
 	FileName    string      `json:"fileName" binding:"required"`
@@ -49,14 +92,36 @@ type FileUploadRequest struct {
 	ParentID    *uint       `json:"parentId"`
 	Hash        string      `json:"hash"`       // 文件MD5哈希
 	ChunkCount  int         `json:"chunkCount"` // 分片数量
+	ChunkSize   int64       `json:"chunkSize"`  // 分片大小，为0时使用服务端的默认分片大小
 	StorageType StorageType `json:"storageType" binding:"required"`
+	SFTPTarget  string      `json:"sftpTarget"` // SFTP目标名称，为空时使用默认目标
 }
 
 // 分片上传请求
 type ChunkUploadRequest struct {
 	FileID     uint   `json:"fileId" binding:"required"`
-	ChunkIndex int    `json:"chunkIndex" binding:"required"`
+	ChunkIndex int    `json:"chunkIndex" binding:"min=0"`   // 分片序号，从0开始
 	ChunkData  string `json:"chunkData" binding:"required"` // Base64编码的分片数据
+	ChunkChecksum
+}
+
+// 分片校验和，Value为空时不校验
+type ChunkChecksum struct {
+	Algorithm string `json:"checksumAlgorithm"` // md5或sha256，默认md5
+	Value     string `json:"checksum"`          // 十六进制摘要
+}
+
+// 断点续传查询请求
+type ResumeUploadRequest struct {
+	FileName string `form:"fileName" binding:"required"`
+	ParentID *uint  `form:"parentId"`
+	Hash     string `form:"hash" binding:"required"`
+}
+
+// 断点续传查询响应
+type ResumeUploadResponse struct {
+	File          File  `json:"file"`
+	MissingChunks []int `json:"missingChunks"` // 尚未上传的分片序号
 }
 
 // 文件列表请求
@@ -78,6 +143,12 @@ type FileListResponse struct {
 	TotalPages int    `json:"totalPages"`
 }
 
+// 回收站列表请求
+type TrashListRequest struct {
+	Page     int `form:"page,default=1"`
+	PageSize int `form:"pageSize,default=20"`
+}
+
 // 创建文件夹请求
 type CreateFolderRequest struct {
 	FolderName string `json:"folderName" binding:"required"`
@@ -96,21 +167,198 @@ type MoveFileRequest struct {
 	ParentID *uint `json:"parentId"`
 }
 
+// 复制文件请求
+type CopyFileRequest struct {
+	FileID   uint  `json:"fileId" binding:"required"`
+	ParentID *uint `json:"parentId"`
+}
+
+// 批量删除请求
+type BatchDeleteRequest struct {
+	FileIDs []uint `json:"fileIds" binding:"required,min=1,max=1000"`
+}
+
+// 批量移动/复制请求
+type BatchTransferRequest struct {
+	FileIDs  []uint `json:"fileIds" binding:"required,min=1,max=1000"`
+	ParentID *uint  `json:"parentId"`
+}
+
+// 批量操作结果
+type BatchResult struct {
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
+
+// 批量操作中单项的结果
+type BatchItemResult struct {
+	FileID  uint   `json:"fileId"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	File    *File  `json:"file,omitempty"` // 复制时为新创建的副本
+}
+
+// 打包下载请求，ids可重复出现，如?ids=1&ids=2
+type ZipDownloadRequest struct {
+	FileIDs []uint `form:"ids" binding:"required,min=1,max=1000"`
+}
+
 // SFTP配置
 type SFTPConfig struct {
 	Host     string `json:"host" binding:"required"`
 	Port     int    `json:"port" binding:"required"`
 	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
+	Password string `json:"password"` // 配置了私钥或ssh-agent时可以为空
 	BasePath string `json:"basePath"` // SFTP服务器上的基础路径
+
+	// 私钥认证，PrivateKey为PEM内容，PrivateKeyPath为私钥文件路径，二者取其一
+	PrivateKey           string `json:"privateKey,omitempty"`
+	PrivateKeyPath       string `json:"privateKeyPath,omitempty"`
+	PrivateKeyPassphrase string `json:"privateKeyPassphrase,omitempty"`
+	UseAgent             bool   `json:"useAgent"` // 使用SSH_AUTH_SOCK指向的ssh-agent
+
+	// 主机密钥校验，配置了指纹时只接受这些指纹，否则按known_hosts文件校验
+	KnownHostsPath        string   `json:"knownHostsPath,omitempty"`
+	HostKeyFingerprints   []string `json:"hostKeyFingerprints,omitempty"` // 如SHA256:xxxx
+	InsecureIgnoreHostKey bool     `json:"insecureIgnoreHostKey"`         // 跳过主机密钥校验，仅用于测试环境
+
+	// 分片按偏移直接写入目标文件，合并时只需重命名。服务器不支持随机写入时可关闭，改为逐个分片复制合并
+	DisableDirectWrite bool `json:"disableDirectWrite"`
+
+	// 连接池配置
+	MaxConns            int           `json:"maxConns"`            // 最大连接数
+	IdleTimeout         time.Duration `json:"idleTimeout"`         // 空闲连接超过该时间后关闭
+	HealthCheckInterval time.Duration `json:"healthCheckInterval"` // 空闲超过该时间的连接复用前先检查可用性
+	WaitTimeout         time.Duration `json:"waitTimeout"`         // 所有连接都在建立中时等待名额的最长时间
+}
+
+// SFTP连接测试结果
+type SFTPTestResult struct {
+	AuthMethod string `json:"authMethod"` // password、privateKey或agent
+}
+
+// SFTP连接池统计
+type SFTPPoolStats struct {
+	Target              string `json:"target"` // user@host:port
+	MaxConns            int    `json:"maxConns"`
+	Open                int    `json:"open"`
+	Idle                int    `json:"idle"`
+	InUse               int    `json:"inUse"`
+	Operations          int    `json:"operations"` // 正在进行的操作数，多个操作可以共享一条连接
+	Waiting             int64  `json:"waiting"`
+	Dials               int64  `json:"dials"`
+	DialErrors          int64  `json:"dialErrors"`
+	Reuses              int64  `json:"reuses"`
+	HealthCheckFailures int64  `json:"healthCheckFailures"`
+	Dropped             int64  `json:"dropped"`    // 服务端断开的连接
+	IdleClosed          int64  `json:"idleClosed"` // 因空闲超时关闭的连接
+	WaitTimeouts        int64  `json:"waitTimeouts"`
+}
+
+// S3兼容对象存储配置
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	UseSSL    bool   `json:"useSSL"`
+}
+
+// 过期上传清理结果
+type UploadCleanupResult struct {
+	ExpiredFiles   []uint   `json:"expiredFiles"`     // 标记为失败的过期上传
+	CleanedFiles   []uint   `json:"cleanedFiles"`     // 清理了分片数据的文件
+	ReclaimedBytes int64    `json:"reclaimedBytes"`   // 释放的分片数据大小
+	Errors         []string `json:"errors,omitempty"` // 清理失败的文件及原因
+}
+
+// 上传限制
+type UploadLimits struct {
+	MaxFileSize  int64                 `json:"maxFileSize"`  // 单个文件的大小上限
+	ChunkSize    int64                 `json:"chunkSize"`    // 默认分片大小
+	MaxChunkSize int64                 `json:"maxChunkSize"` // 允许的最大分片大小
+	MinChunkSize map[StorageType]int64 `json:"minChunkSize"` // 部分存储类型要求的最小分片大小（最后一个分片除外）
+	AllowedTypes []string              `json:"allowedTypes"` // 允许上传的扩展名，"*"表示全部
+	SFTPTargets  []string              `json:"sftpTargets"`  // 可选的SFTP目标名称
+}
+
+// 存储空间使用情况
+type StorageUsage struct {
+	Quota      int64                 `json:"quota"`               // 配额（字节），不限制时为0
+	Unlimited  bool                  `json:"unlimited"`           // 是否不限制配额
+	Used       int64                 `json:"used"`                // 已使用，包括上传中的文件和回收站
+	Remaining  *int64                `json:"remaining,omitempty"` // 剩余空间，不限制时为空
+	FileCount  int64                 `json:"fileCount"`           // 文件数，不包括回收站
+	TrashBytes int64                 `json:"trashBytes"`          // 回收站占用的空间
+	ByStorage  map[StorageType]int64 `json:"byStorage"`           // 按存储类型统计的已用空间
 }
 
 // 文件上传进度
 type UploadProgress struct {
-	FileID       uint       `json:"fileId"`
-	FileName     string     `json:"fileName"`
-	TotalSize    int64      `json:"totalSize"`
-	UploadedSize int64      `json:"uploadedSize"`
-	Progress     float64    `json:"progress"` // 0-100
-	Status       FileStatus `json:"status"`
+	FileID        uint       `json:"fileId"`
+	FileName      string     `json:"fileName"`
+	TotalSize     int64      `json:"totalSize"`
+	UploadedSize  int64      `json:"uploadedSize"`
+	Progress      float64    `json:"progress"`                // 0-100
+	MergeProgress float64    `json:"mergeProgress,omitempty"` // 合并进度0-100，仅合并中有效
+	Status        FileStatus `json:"status"`
+	FailReason    string     `json:"failReason,omitempty"`
+}
+
+// 文件事件类型
+type FileEventType string
+
+const (
+	FileEventProgress      FileEventType = "progress"       // 连接建立时推送的当前进度
+	FileEventChunkAccepted FileEventType = "chunk_accepted" // 分片已校验并记录
+	FileEventMergeStarted  FileEventType = "merge_started"
+	FileEventMergeProgress FileEventType = "merge_progress"
+	FileEventCompleted     FileEventType = "completed"
+	FileEventFailed        FileEventType = "failed"
+)
+
+// 通过事件流推送给用户的上传事件
+type FileEvent struct {
+	Type           FileEventType `json:"type"`
+	FileID         uint          `json:"fileId"`
+	FileName       string        `json:"fileName"`
+	Status         FileStatus    `json:"status"`
+	ChunkIndex     *int          `json:"chunkIndex,omitempty"`
+	UploadedChunks int           `json:"uploadedChunks"`
+	ChunkCount     int           `json:"chunkCount"`
+	UploadedSize   int64         `json:"uploadedSize"` // 已记录分片的实际字节数
+	TotalSize      int64         `json:"totalSize"`
+	MergedChunks   int           `json:"mergedChunks,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	Time           time.Time     `json:"time"`
+}
+
+// 事件流票据，用于 EventSource 连接 /api/files/events?ticket=...
+type EventTicketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expiresIn"` // 秒
+}
+
+// 文件详情响应
+type FileInfoResponse struct {
+	File    File             `json:"file"`
+	Storage *StorageFileInfo `json:"storage,omitempty"` // 存储后端中的实际文件信息，文件夹为空
+	Path    []PathItem       `json:"path"`              // 从根目录到所在文件夹的路径
+}
+
+// 存储后端中的实际文件信息
+type StorageFileInfo struct {
+	Exists    bool       `json:"exists"`
+	Size      int64      `json:"size"`
+	ModTime   *time.Time `json:"modTime,omitempty"`
+	SizeMatch bool       `json:"sizeMatch"` // 实际大小是否与FileSize一致
+	Error     string     `json:"error,omitempty"`
+}
+
+// 路径中的文件夹
+type PathItem struct {
+	ID       uint   `json:"id"`
+	FileName string `json:"fileName"`
 }
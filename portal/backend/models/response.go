@@ -35,6 +35,12 @@ type UserListResponse struct {
 	PageSize int    `json:"pageSize"`
 }
 
+type UpdateUserQuotaRequest struct {
+	UserID    uint   `json:"userId" binding:"required"`
+	Quota     *int64 `json:"quota" binding:"omitempty,min=0"` // 为空时恢复为角色的默认配额，0表示不能再占用空间
+	Unlimited bool   `json:"unlimited"`                       // 不限制配额，不能与Quota同时设置
+}
+
 type UpdateUserRoleRequest struct {
 	UserID uint     `json:"userId" binding:"required"`
 	Role   UserRole `json:"role" binding:"required"`
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// 命名的SFTP存储目标，密码、私钥和私钥口令加密存储
+type SFTPTarget struct {
+	ID                    uint      `json:"id" gorm:"primaryKey"`
+	Name                  string    `json:"name" gorm:"uniqueIndex;not null"`
+	Host                  string    `json:"host" gorm:"not null"`
+	Port                  int       `json:"port" gorm:"not null"`
+	Username              string    `json:"username" gorm:"not null"`
+	BasePath              string    `json:"basePath" gorm:"column:base_path;not null"`
+	PasswordEnc           string    `json:"-" gorm:"column:password_enc"`
+	PrivateKeyEnc         string    `json:"-" gorm:"column:private_key_enc"`
+	PassphraseEnc         string    `json:"-" gorm:"column:passphrase_enc"`
+	PrivateKeyPath        string    `json:"privateKeyPath,omitempty" gorm:"column:private_key_path"`
+	UseAgent              bool      `json:"useAgent" gorm:"column:use_agent;default:false"`
+	KnownHostsPath        string    `json:"knownHostsPath,omitempty" gorm:"column:known_hosts_path"`
+	HostKeyFingerprints   []string  `json:"hostKeyFingerprints" gorm:"column:host_key_fingerprints;serializer:json"`
+	InsecureIgnoreHostKey bool      `json:"insecureIgnoreHostKey" gorm:"column:insecure_ignore_host_key;default:false"`
+	DisableDirectWrite    bool      `json:"disableDirectWrite" gorm:"column:disable_direct_write;default:false"`
+	IsDefault             bool      `json:"isDefault" gorm:"column:is_default;default:false"` // 上传时未指定目标则使用默认目标
+	HasPassword           bool      `json:"hasPassword" gorm:"-"`
+	HasPrivateKey         bool      `json:"hasPrivateKey" gorm:"-"`
+	CreatedAt             time.Time `json:"createdAt"`
+	UpdatedAt             time.Time `json:"updatedAt"`
+}
+
+// 创建或更新SFTP目标的请求。更新时密码、私钥和私钥口令为nil表示保持不变，空字符串表示清除
+type SFTPTargetRequest struct {
+	Name                  string   `json:"name" binding:"required,max=64"`
+	Host                  string   `json:"host" binding:"required"`
+	Port                  int      `json:"port" binding:"required,min=1,max=65535"`
+	Username              string   `json:"username" binding:"required"`
+	Password              *string  `json:"password"`
+	BasePath              string   `json:"basePath" binding:"required"`
+	PrivateKey            *string  `json:"privateKey"`
+	PrivateKeyPassphrase  *string  `json:"privateKeyPassphrase"`
+	PrivateKeyPath        string   `json:"privateKeyPath"`
+	UseAgent              bool     `json:"useAgent"`
+	KnownHostsPath        string   `json:"knownHostsPath"`
+	HostKeyFingerprints   []string `json:"hostKeyFingerprints"`
+	InsecureIgnoreHostKey bool     `json:"insecureIgnoreHostKey"`
+	DisableDirectWrite    bool     `json:"disableDirectWrite"`
+	IsDefault             bool     `json:"isDefault"`
+}
+
+// 测试SFTP连接的请求，可指定目标名称或临时配置，都为空时测试默认目标
+type SFTPTestRequest struct {
+	Target string      `json:"target"`
+	Config *SFTPConfig `json:"config"`
+}
@@ -14,6 +14,8 @@ type User struct {
 	Username    string     `json:"username" gorm:"uniqueIndex;not null"`
 	Password    string     `json:"-" gorm:"not null"` // 不在JSON中返回
 	Role        UserRole   `json:"role" gorm:"default:'user'"`
+	Quota       *int64     `json:"quota"`                                   // 存储配额（字节），nil表示使用角色的默认配额，0表示不能再占用空间
+	Unlimited   bool       `json:"unlimited" gorm:"column:quota_unlimited"` // 不限制存储配额，优先于Quota
 	Avatar      string     `json:"avatar,omitempty"`
 	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
 	CreatedAt   time.Time  `json:"createdAt"`
@@ -197,6 +197,27 @@ func (s *AuthService) GetUserList(req *models.UserListRequest, currentUserID uin
 	}, nil
 }
 
+// 更新用户的存储配额，quota为nil时恢复为角色的默认配额
+func (s *AuthService) UpdateUserQuota(userID uint, quota *int64, unlimited bool) error {
+	if unlimited && quota != nil {
+		return errors.New("不能同时设置配额和不限制配额")
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+
+	if err := s.db.Model(&user).Updates(map[string]interface{}{
+		"quota":           quota,
+		"quota_unlimited": unlimited,
+	}).Error; err != nil {
+		return errors.New("更新配额失败")
+	}
+
+	return nil
+}
+
 // 更新用户角色
 func (s *AuthService) UpdateUserRole(userID uint, newRole models.UserRole, operatorID uint) error {
 	var user models.User
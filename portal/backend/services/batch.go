@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+
+	"go-auth-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 在一个事务中逐项执行批量操作，每一项使用独立的保存点，
+// 单项失败只回滚该项并记录原因，不影响其他项
+func (s *FileService) runBatch(fileIDs []uint, op func(tx *gorm.DB, fileID uint) (*models.File, error)) (*models.BatchResult, error) {
+	result := &models.BatchResult{Results: make([]models.BatchItemResult, 0, len(fileIDs))}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, fileID := range fileIDs {
+			item := models.BatchItemResult{FileID: fileID}
+
+			err := tx.Transaction(func(itemTx *gorm.DB) error {
+				file, err := op(itemTx, fileID)
+				item.File = file
+				return err
+			})
+			if err != nil {
+				item.Error = err.Error()
+				result.Failed++
+			} else {
+				item.Success = true
+				result.Succeeded++
+			}
+			result.Results = append(result.Results, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("批量操作失败: %v", err)
+	}
+
+	return result, nil
+}
+
+// 批量删除
+func (s *FileService) BatchDeleteFiles(userID uint, req *models.BatchDeleteRequest) (*models.BatchResult, error) {
+	return s.runBatch(req.FileIDs, func(tx *gorm.DB, fileID uint) (*models.File, error) {
+		return nil, s.deleteFile(tx, userID, fileID)
+	})
+}
+
+// 批量移动
+func (s *FileService) BatchMoveFiles(userID uint, req *models.BatchTransferRequest) (*models.BatchResult, error) {
+	return s.runBatch(req.FileIDs, func(tx *gorm.DB, fileID uint) (*models.File, error) {
+		return nil, s.moveFile(tx, userID, fileID, req.ParentID)
+	})
+}
+
+// 批量复制，结果中包含新创建的副本
+func (s *FileService) BatchCopyFiles(userID uint, req *models.BatchTransferRequest) (*models.BatchResult, error) {
+	return s.runBatch(req.FileIDs, func(tx *gorm.DB, fileID uint) (*models.File, error) {
+		return s.copyFile(tx, userID, fileID, req.ParentID)
+	})
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go-auth-server/models"
+	"go-auth-server/utils"
+)
+
+// 每个订阅者的缓冲事件数，消费过慢时丢弃新事件而不是阻塞上传
+const eventBufferSize = 64
+
+// 按用户分发文件事件
+type EventHub struct {
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan models.FileEvent]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[uint]map[chan models.FileEvent]struct{})}
+}
+
+// 订阅用户的事件，返回的函数用于取消订阅
+func (h *EventHub) Subscribe(userID uint) (<-chan models.FileEvent, func()) {
+	ch := make(chan models.FileEvent, eventBufferSize)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan models.FileEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			h.mu.Unlock()
+		})
+	}
+}
+
+func (h *EventHub) Publish(userID uint, event models.FileEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for ch := range h.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// 订阅当前用户的文件事件
+func (s *FileService) SubscribeEvents(userID uint) (<-chan models.FileEvent, func()) {
+	return s.events.Subscribe(userID)
+}
+
+// 发布文件事件，补全文件信息和已上传的分片统计
+func (s *FileService) publishEvent(file *models.File, eventType models.FileEventType, event models.FileEvent) {
+	event.Type = eventType
+	event.FileID = file.ID
+	event.FileName = file.FileName
+	event.ChunkCount = file.ChunkCount
+	event.TotalSize = file.FileSize
+	event.Time = time.Now()
+
+	switch eventType {
+	case models.FileEventMergeStarted, models.FileEventMergeProgress:
+		event.Status = models.FileStatusMerging
+		event.UploadedChunks = file.ChunkCount
+		event.UploadedSize = file.FileSize
+	case models.FileEventCompleted:
+		event.Status = models.FileStatusCompleted
+		event.UploadedChunks = file.ChunkCount
+		event.UploadedSize = file.FileSize
+	case models.FileEventFailed:
+		event.Status = models.FileStatusFailed
+		event.UploadedChunks, event.UploadedSize, _ = s.chunkStats(file.ID)
+	default:
+		if event.Status == "" {
+			event.Status = file.Status
+		}
+	}
+
+	s.events.Publish(file.UserID, event)
+}
+
+// 统计已记录的分片数量和实际字节数
+func (s *FileService) chunkStats(fileID uint) (int, int64, error) {
+	var stats struct {
+		Count int64
+		Size  int64
+	}
+	err := s.db.Model(&models.FileChunk{}).Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS size").
+		Where("file_id = ?", fileID).Scan(&stats).Error
+	return int(stats.Count), stats.Size, err
+}
+
+// 用户当前进行中的上传，事件流建立连接时先推送一次，客户端无需再轮询进度
+func (s *FileService) PendingUploadEvents(userID uint) []models.FileEvent {
+	var files []models.File
+	s.db.Where("user_id = ? AND deleted_at IS NULL AND status IN ?", userID,
+		[]models.FileStatus{models.FileStatusUploading, models.FileStatusMerging}).
+		Order("id").Find(&files)
+
+	events := make([]models.FileEvent, 0, len(files))
+	for i := range files {
+		file := &files[i]
+		event := models.FileEvent{
+			Type:       models.FileEventProgress,
+			FileID:     file.ID,
+			FileName:   file.FileName,
+			Status:     file.Status,
+			ChunkCount: file.ChunkCount,
+			TotalSize:  file.FileSize,
+			Time:       time.Now(),
+		}
+		event.UploadedChunks, event.UploadedSize, _ = s.chunkStats(file.ID)
+		if merged, ok := s.mergeProgress.Load(file.ID); ok {
+			event.MergedChunks = merged.(int)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// 签发连接事件流的一次性票据
+func (s *FileService) IssueEventTicket(userID uint, username, role string) (*models.EventTicketResponse, error) {
+	ticket, err := utils.IssueStreamTicket(userID, username, role)
+	if err != nil {
+		return nil, fmt.Errorf("生成事件流票据失败: %v", err)
+	}
+	return &models.EventTicketResponse{
+		Ticket:    ticket,
+		ExpiresIn: int(utils.StreamTicketTTL / time.Second),
+	}, nil
+}
@@ -0,0 +1,540 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"path"
+	"strings"
+
+	"go-auth-server/config"
+	"go-auth-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 支持解压的压缩包格式
+type archiveFormat int
+
+const (
+	archiveUnsupported archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGz
+	archiveGz // 单个文件的gzip压缩
+)
+
+func detectArchiveFormat(fileName string) (archiveFormat, string) {
+	name := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip, fileName[:len(fileName)-len(".zip")]
+	case strings.HasSuffix(name, ".tar.gz"):
+		return archiveTarGz, fileName[:len(fileName)-len(".tar.gz")]
+	case strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz, fileName[:len(fileName)-len(".tgz")]
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar, fileName[:len(fileName)-len(".tar")]
+	case strings.HasSuffix(name, ".gz"):
+		return archiveGz, fileName[:len(fileName)-len(".gz")]
+	}
+	return archiveUnsupported, fileName
+}
+
+// 创建解压任务，解压结果放在压缩包所在目录下的同名新文件夹中
+func (s *FileService) StartExtract(userID uint, fileID uint) (*models.ExtractJob, error) {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+		return nil, fmt.Errorf("文件不存在")
+	}
+	if file.IsFolder {
+		return nil, fmt.Errorf("不能解压文件夹")
+	}
+	if file.Status != models.FileStatusCompleted {
+		return nil, fmt.Errorf("文件尚未上传完成")
+	}
+
+	format, baseName := detectArchiveFormat(file.FileName)
+	if format == archiveUnsupported {
+		return nil, fmt.Errorf("不支持解压该格式，仅支持zip、tar、tar.gz和gz")
+	}
+	if baseName == "" {
+		baseName = file.FileName
+	}
+
+	job := &models.ExtractJob{
+		UserID: userID,
+		FileID: file.ID,
+		Status: models.ExtractStatusPending,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		folder := &models.File{
+			UserID:       userID,
+			FileName:     s.uniqueFileName(tx, userID, file.ParentID, baseName),
+			OriginalName: baseName,
+			MimeType:     "folder",
+			StorageType:  models.StorageLocal,
+			Status:       models.FileStatusCompleted,
+			ParentID:     file.ParentID,
+			IsFolder:     true,
+		}
+		if err := tx.Create(folder).Error; err != nil {
+			return fmt.Errorf("创建文件夹失败: %v", err)
+		}
+
+		job.FolderID = folder.ID
+		if err := tx.Create(job).Error; err != nil {
+			return fmt.Errorf("创建解压任务失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueueExtract(job.ID)
+	return job, nil
+}
+
+// 获取解压任务
+func (s *FileService) GetExtractJob(userID uint, jobID uint) (*models.ExtractJob, error) {
+	var job models.ExtractJob
+	if err := s.db.Where("id = ? AND user_id = ?", jobID, userID).First(&job).Error; err != nil {
+		return nil, fmt.Errorf("解压任务不存在")
+	}
+	return &job, nil
+}
+
+// 启动解压任务工作池。服务重启前正在执行的任务已写入部分文件，无法续做，标记为失败
+func (s *FileService) StartExtractWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		go s.extractWorker()
+	}
+
+	var running []models.ExtractJob
+	s.db.Where("status = ?", models.ExtractStatusRunning).Find(&running)
+	for _, job := range running {
+		s.failExtract(&job, fmt.Errorf("服务重启，解压中断"))
+	}
+
+	var jobIDs []uint
+	s.db.Model(&models.ExtractJob{}).Where("status = ?", models.ExtractStatusPending).Pluck("id", &jobIDs)
+	for _, jobID := range jobIDs {
+		s.enqueueExtract(jobID)
+	}
+}
+
+// 提交解压任务，队列满时不阻塞调用方
+func (s *FileService) enqueueExtract(jobID uint) {
+	select {
+	case s.extractJobs <- jobID:
+	default:
+		go func() { s.extractJobs <- jobID }()
+	}
+}
+
+func (s *FileService) extractWorker() {
+	for jobID := range s.extractJobs {
+		result := s.db.Model(&models.ExtractJob{}).
+			Where("id = ? AND status = ?", jobID, models.ExtractStatusPending).
+			Update("status", models.ExtractStatusRunning)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		var job models.ExtractJob
+		if err := s.db.First(&job, jobID).Error; err != nil {
+			continue
+		}
+
+		if err := s.runExtract(&job); err != nil {
+			log.Printf("解压任务 %d 失败: %v", job.ID, err)
+			s.failExtract(&job, err)
+		}
+	}
+}
+
+// 已解压的部分移入回收站后再标记任务失败，任务显示失败时不会再看到部分结果
+func (s *FileService) failExtract(job *models.ExtractJob, err error) {
+	s.deleteFile(s.db, job.UserID, job.FolderID)
+	s.db.Model(job).Updates(map[string]interface{}{
+		"status": models.ExtractStatusFailed,
+		"error":  err.Error(),
+	})
+}
+
+func (s *FileService) runExtract(job *models.ExtractJob) error {
+	var archive models.File
+	if err := s.db.Where("id = ? AND deleted_at IS NULL", job.FileID).First(&archive).Error; err != nil {
+		return fmt.Errorf("压缩包不存在")
+	}
+
+	storage, err := s.storageFor(&archive)
+	if err != nil {
+		return err
+	}
+
+	reader, err := storage.OpenFile(&archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	ex := &extractor{
+		s:       s,
+		job:     job,
+		archive: &archive,
+		storage: storage,
+		role:    s.userRole(job.UserID),
+		folders: map[string]uint{"": job.FolderID},
+	}
+
+	format, baseName := detectArchiveFormat(archive.FileName)
+	if format != archiveZip {
+		ex.archiveRead = &countingReader{reader: reader}
+	}
+
+	switch format {
+	case archiveZip:
+		err = ex.extractZip(reader)
+	case archiveTar:
+		err = ex.extractTar(ex.archiveRead)
+	case archiveTarGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(ex.archiveRead); err == nil {
+			err = ex.extractTar(gz)
+		}
+	case archiveGz:
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(ex.archiveRead); err == nil {
+			err = ex.extractFile(baseName, gz, -1)
+		}
+	default:
+		err = fmt.Errorf("不支持解压该格式")
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.db.Model(job).Updates(map[string]interface{}{
+		"status":   models.ExtractStatusCompleted,
+		"progress": 100,
+	}).Error
+}
+
+// 解压过程中的状态
+type extractor struct {
+	s       *FileService
+	job     *models.ExtractJob
+	archive *models.File
+	storage Storage
+	role    string          // 任务所属用户的角色，用于文件类型策略
+	folders map[string]uint // 压缩包内的目录 -> 文件夹ID
+	entries int             // 已读取的条目数，包括跳过的条目
+	// tar格式事先不知道条目数，按已读取的压缩包字节计算进度
+	archiveRead *countingReader
+}
+
+func (ex *extractor) extractZip(reader io.ReadSeekCloser) error {
+	readerAt, ok := reader.(io.ReaderAt)
+	if !ok {
+		return fmt.Errorf("存储后端不支持随机读取，无法解压zip")
+	}
+
+	zr, err := zip.NewReader(readerAt, ex.archive.FileSize)
+	if err != nil {
+		return fmt.Errorf("读取zip文件失败: %v", err)
+	}
+
+	if len(zr.File) > ex.s.config.ExtractMaxEntries {
+		return fmt.Errorf("压缩包条目数超过限制: %d", ex.s.config.ExtractMaxEntries)
+	}
+	ex.job.TotalEntries = len(zr.File)
+
+	for _, entry := range zr.File {
+		if err := ex.extractZipEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ex *extractor) extractZipEntry(entry *zip.File) error {
+	mode := entry.Mode()
+	switch {
+	case mode.IsDir():
+		return ex.extractDir(entry.Name)
+	case !mode.IsRegular():
+		return ex.skip()
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("读取 %s 失败: %v", entry.Name, err)
+	}
+	defer rc.Close()
+
+	return ex.extractFile(entry.Name, rc, int64(entry.UncompressedSize64))
+}
+
+func (ex *extractor) extractTar(reader io.Reader) error {
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取tar文件失败: %v", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = ex.extractDir(header.Name)
+		case tar.TypeReg:
+			err = ex.extractFile(header.Name, tr, header.Size)
+		default:
+			// 链接、设备文件等不解压
+			err = ex.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 检查条目路径，拒绝绝对路径和跳出目标目录的路径，返回清理后的相对路径
+func sanitizeEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) >= 2 && name[1] == ':') {
+		return "", fmt.Errorf("压缩包包含非法路径: %s", name)
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("压缩包包含非法路径: %s", name)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// 计数并检查条目数限制
+func (ex *extractor) countEntry() error {
+	ex.entries++
+	if ex.entries > ex.s.config.ExtractMaxEntries {
+		return fmt.Errorf("压缩包条目数超过限制: %d", ex.s.config.ExtractMaxEntries)
+	}
+	return nil
+}
+
+func (ex *extractor) skip() error {
+	if err := ex.countEntry(); err != nil {
+		return err
+	}
+	ex.job.SkippedEntries++
+	return ex.saveProgress()
+}
+
+func (ex *extractor) extractDir(name string) error {
+	dir, err := sanitizeEntryPath(name)
+	if err != nil {
+		return err
+	}
+	if err := ex.countEntry(); err != nil {
+		return err
+	}
+	if _, err := ex.ensureFolder(dir); err != nil {
+		return err
+	}
+	ex.job.ProcessedEntries++
+	return ex.saveProgress()
+}
+
+// 按路径逐级创建文件夹，返回最后一级文件夹的ID
+func (ex *extractor) ensureFolder(dir string) (uint, error) {
+	if folderID, ok := ex.folders[dir]; ok {
+		return folderID, nil
+	}
+
+	parentDir := path.Dir(dir)
+	if parentDir == "." {
+		parentDir = ""
+	}
+	parentID, err := ex.ensureFolder(parentDir)
+	if err != nil {
+		return 0, err
+	}
+
+	name := path.Base(dir)
+	folder := &models.File{
+		UserID:       ex.job.UserID,
+		FileName:     ex.s.uniqueFileName(ex.s.db, ex.job.UserID, &parentID, name),
+		OriginalName: name,
+		MimeType:     "folder",
+		StorageType:  models.StorageLocal,
+		Status:       models.FileStatusCompleted,
+		ParentID:     &parentID,
+		IsFolder:     true,
+	}
+	if err := ex.s.db.Create(folder).Error; err != nil {
+		return 0, fmt.Errorf("创建文件夹失败: %v", err)
+	}
+
+	ex.folders[dir] = folder.ID
+	return folder.ID, nil
+}
+
+// 解压单个文件，写入压缩包所在的存储后端。size为条目声明的大小，未知时为-1。
+// zip和tar的读取器保证读出的数据与声明的大小一致，已知大小时存储后端可以直接流式写入
+func (ex *extractor) extractFile(name string, data io.Reader, size int64) error {
+	filePath, err := sanitizeEntryPath(name)
+	if err != nil {
+		return err
+	}
+	fileName := path.Base(filePath)
+	if filePath == "" || ex.s.ValidateFileType(ex.role, ex.archive.StorageType, fileName) != nil {
+		return ex.skip()
+	}
+	if err := ex.countEntry(); err != nil {
+		return err
+	}
+
+	dir := path.Dir(filePath)
+	if dir == "." {
+		dir = ""
+	}
+	parentID, err := ex.ensureFolder(dir)
+	if err != nil {
+		return err
+	}
+
+	// 以实际读出的字节数限制大小，不信任压缩包中声明的大小。
+	// 剩余配额在插入条目自身的记录之前计算，否则条目声明的大小会被重复扣除
+	limit := ex.s.config.ExtractMaxSize - ex.job.ExtractedBytes
+	if fileLimit := ex.s.GetFileSizeLimit(); fileLimit < limit {
+		limit = fileLimit
+	}
+	remaining, err := ex.s.remainingQuota(ex.s.db, ex.job.UserID)
+	if err != nil {
+		return err
+	}
+	quotaLimited := remaining >= 0 && remaining < limit
+	if quotaLimited {
+		limit = remaining
+	}
+
+	file := &models.File{
+		UserID:         ex.job.UserID,
+		FileName:       ex.s.uniqueFileName(ex.s.db, ex.job.UserID, &parentID, fileName),
+		OriginalName:   fileName,
+		FilePath:       ex.storage.GenerateFilePath(ex.job.UserID, fileName),
+		MimeType:       mime.TypeByExtension(strings.ToLower(path.Ext(fileName))),
+		StorageType:    ex.archive.StorageType,
+		SFTPTargetID:   ex.archive.SFTPTargetID,
+		Status:         models.FileStatusUploading,
+		ParentID:       &parentID,
+		ChunkCount:     1,
+		UploadedChunks: "[]",
+	}
+	if size > 0 {
+		file.FileSize = size
+		file.ChunkSize = size
+	}
+	initializer, initialized := ex.storage.(UploadInitializer)
+	if initialized {
+		if err := initializer.InitUpload(file); err != nil {
+			return err
+		}
+	}
+	if err := ex.s.db.Create(file).Error; err != nil {
+		// 没有记录就不会再被清理，中止已创建的上传会话
+		if initialized {
+			ex.storage.DeleteChunks(file)
+		}
+		return fmt.Errorf("创建文件记录失败: %v", err)
+	}
+
+	hasher := md5.New()
+	sniff := &sniffBuffer{}
+	counter := &countingReader{reader: io.TeeReader(io.LimitReader(data, limit+1), io.MultiWriter(hasher, sniff))}
+
+	// 声明的大小超过限制时不写入存储
+	if size > limit {
+		err = ex.tooLarge(filePath, quotaLimited)
+	} else {
+		err = ex.storage.SaveChunk(file, 0, counter)
+		if err == nil && counter.n > limit {
+			err = ex.tooLarge(filePath, quotaLimited)
+		}
+	}
+
+	mimeType, mismatch := detectFileType(fileName, sniff.buf)
+	if err == nil && mismatch && ex.s.config.FileTypes.MismatchAction != config.MismatchFlag {
+		// 内容与扩展名不符的条目不解压
+		ex.storage.DeleteChunks(file)
+		ex.s.db.Delete(file)
+		ex.job.SkippedEntries++
+		return ex.saveProgress()
+	}
+	if err == nil {
+		err = ex.storage.MergeChunks(file, func(int) {})
+	}
+	if err != nil {
+		ex.storage.DeleteChunks(file)
+		ex.s.db.Model(file).Updates(map[string]interface{}{
+			"status":      models.FileStatusFailed,
+			"fail_reason": err.Error(),
+		})
+		return err
+	}
+
+	if err := ex.s.db.Model(file).Updates(map[string]interface{}{
+		"status":        models.FileStatusCompleted,
+		"file_size":     counter.n,
+		"hash":          hex.EncodeToString(hasher.Sum(nil)),
+		"mime_type":     mimeType,
+		"type_mismatch": mismatch,
+	}).Error; err != nil {
+		return fmt.Errorf("更新文件状态失败: %v", err)
+	}
+
+	ex.job.ExtractedBytes += counter.n
+	ex.job.ProcessedEntries++
+	return ex.saveProgress()
+}
+
+func (ex *extractor) tooLarge(filePath string, quotaLimited bool) error {
+	if quotaLimited {
+		return fmt.Errorf("存储空间不足，无法解压 %s", filePath)
+	}
+	return fmt.Errorf("%s 解压后大小超过限制", filePath)
+}
+
+func (ex *extractor) saveProgress() error {
+	switch {
+	case ex.job.TotalEntries > 0:
+		ex.job.Progress = float64(ex.entries) / float64(ex.job.TotalEntries) * 100
+	case ex.archiveRead != nil && ex.archive.FileSize > 0:
+		ex.job.Progress = float64(ex.archiveRead.n) / float64(ex.archive.FileSize) * 100
+	}
+	if ex.job.Progress > 100 {
+		ex.job.Progress = 100
+	}
+
+	return ex.s.db.Model(ex.job).Updates(map[string]interface{}{
+		"total_entries":     ex.job.TotalEntries,
+		"processed_entries": ex.job.ProcessedEntries,
+		"skipped_entries":   ex.job.SkippedEntries,
+		"extracted_bytes":   ex.job.ExtractedBytes,
+		"progress":          ex.job.Progress,
+	}).Error
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"go-auth-server/models"
+)
+
+type archiveEntry struct {
+	name string
+	body string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatalf("写入zip条目失败: %v", err)
+		}
+		w.Write([]byte(entry.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("生成zip失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.body)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("写入tar条目失败: %v", err)
+		}
+		tw.Write([]byte(entry.body))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("生成tar失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func newTestExtractService(t *testing.T) (*FileService, *memoryStorage) {
+	t.Helper()
+
+	service, storage := newTestFileService(t)
+	if err := service.db.AutoMigrate(&models.ExtractJob{}); err != nil {
+		t.Fatalf("迁移数据库失败: %v", err)
+	}
+	service.StartExtractWorkers(1)
+	return service, storage
+}
+
+// 将压缩包写入内存存储并解压，返回结束时的任务
+func extractArchive(t *testing.T, service *FileService, storage *memoryStorage, fileName string, data []byte) models.ExtractJob {
+	t.Helper()
+
+	archive := &models.File{
+		UserID:         1,
+		FileName:       fileName,
+		OriginalName:   fileName,
+		FilePath:       storage.GenerateFilePath(1, fileName),
+		FileSize:       int64(len(data)),
+		StorageType:    storageMemory,
+		Status:         models.FileStatusCompleted,
+		UploadedChunks: "[]",
+	}
+	if err := service.db.Create(archive).Error; err != nil {
+		t.Fatalf("创建压缩包记录失败: %v", err)
+	}
+	storage.mu.Lock()
+	storage.files[archive.FilePath] = data
+	storage.mu.Unlock()
+
+	job, err := service.StartExtract(1, archive.ID)
+	if err != nil {
+		t.Fatalf("创建解压任务失败: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := service.GetExtractJob(1, job.ID)
+		if err != nil {
+			t.Fatalf("获取解压任务失败: %v", err)
+		}
+		if current.Status == models.ExtractStatusCompleted || current.Status == models.ExtractStatusFailed {
+			return *current
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待解压超时，当前状态 %s", current.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectExtractFailed(t *testing.T, job models.ExtractJob, reason string) {
+	t.Helper()
+
+	if job.Status != models.ExtractStatusFailed {
+		t.Fatalf("解压应失败，实际状态 %s", job.Status)
+	}
+	if !strings.Contains(job.Error, reason) {
+		t.Fatalf("失败原因应包含 %q，实际为 %q", reason, job.Error)
+	}
+}
+
+func TestSanitizeEntryPath(t *testing.T) {
+	valid := map[string]string{
+		"a.txt":          "a.txt",
+		"dir/a.txt":      "dir/a.txt",
+		"dir/../a.txt":   "a.txt",
+		"./dir//a.txt":   "dir/a.txt",
+		"dir\\a.txt":     "dir/a.txt",
+		"dir/sub/../../": "",
+	}
+	for name, want := range valid {
+		got, err := sanitizeEntryPath(name)
+		if err != nil || got != want {
+			t.Errorf("sanitizeEntryPath(%q) = %q, %v，期望 %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"../a.txt", "..", "dir/../../a.txt", "/etc/passwd", "\\evil.txt", "C:/evil.txt", "c:evil.txt"} {
+		if _, err := sanitizeEntryPath(name); err == nil {
+			t.Errorf("sanitizeEntryPath(%q) 应返回错误", name)
+		}
+	}
+}
+
+// 跳出目标目录和绝对路径的条目导致任务失败，已解压的部分移入回收站
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "dir/../../evil.txt", "/tmp/evil.txt", "C:/evil.txt"} {
+		t.Run(name, func(t *testing.T) {
+			service, storage := newTestExtractService(t)
+
+			entries := []archiveEntry{{"ok.txt", "ok"}, {name, "evil"}}
+			for _, archive := range []struct {
+				fileName string
+				data     []byte
+			}{
+				{"slip.zip", buildZip(t, entries)},
+				{"slip.tar", buildTar(t, entries)},
+			} {
+				job := extractArchive(t, service, storage, archive.fileName, archive.data)
+				expectExtractFailed(t, job, "非法路径")
+			}
+
+			var live int64
+			service.db.Model(&models.File{}).
+				Where("is_folder = ? AND deleted_at IS NULL AND file_name <> ? AND file_name <> ?", false, "slip.zip", "slip.tar").
+				Count(&live)
+			if live != 0 {
+				t.Fatalf("失败的解压任务不应留下文件，实际 %d 个", live)
+			}
+			var evil int64
+			service.db.Model(&models.File{}).Where("file_name = ?", "evil.txt").Count(&evil)
+			if evil != 0 {
+				t.Fatalf("非法路径的条目不应被解压")
+			}
+		})
+	}
+}
+
+func TestExtractEntryLimit(t *testing.T) {
+	service, storage := newTestExtractService(t)
+	service.config.ExtractMaxEntries = 2
+
+	entries := []archiveEntry{{"a.txt", "a"}, {"b.txt", "b"}, {"c.txt", "c"}}
+	// zip事先检查条目总数，tar在读取过程中计数
+	expectExtractFailed(t, extractArchive(t, service, storage, "many.zip", buildZip(t, entries)), "条目数超过限制")
+	expectExtractFailed(t, extractArchive(t, service, storage, "many.tar", buildTar(t, entries)), "条目数超过限制")
+
+	job := extractArchive(t, service, storage, "few.tar", buildTar(t, entries[:2]))
+	if job.Status != models.ExtractStatusCompleted {
+		t.Fatalf("条目数未超过限制时应解压成功: %s", job.Error)
+	}
+}
+
+func TestExtractSizeLimit(t *testing.T) {
+	service, storage := newTestExtractService(t)
+	service.config.ExtractMaxSize = 10
+
+	// 单个条目未超限，累计超过总大小限制
+	entries := []archiveEntry{{"a.txt", "12345678"}, {"b.txt", "12345678"}}
+	expectExtractFailed(t, extractArchive(t, service, storage, "big.zip", buildZip(t, entries)), "大小超过限制")
+	expectExtractFailed(t, extractArchive(t, service, storage, "big.tar", buildTar(t, entries)), "大小超过限制")
+
+	// gz不知道解压后的大小，按实际读出的字节数限制
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("a"), 1024))
+	zw.Close()
+	expectExtractFailed(t, extractArchive(t, service, storage, "big.txt.gz", gz.Bytes()), "大小超过限制")
+
+	var completed int64
+	service.db.Model(&models.File{}).Where("is_folder = ? AND deleted_at IS NULL AND status = ? AND file_name NOT LIKE ?",
+		false, models.FileStatusCompleted, "big.%").Count(&completed)
+	if completed != 0 {
+		t.Fatalf("失败的解压任务不应留下文件，实际 %d 个", completed)
+	}
+}
+
+// 不同目录下的同名条目写入各自的存储路径，不会互相覆盖
+func TestExtractSameNameEntries(t *testing.T) {
+	service, storage := newTestExtractService(t)
+
+	entries := []archiveEntry{{"a/readme.txt", "AAAA"}, {"b/readme.txt", "BBBB"}}
+	job := extractArchive(t, service, storage, "same.zip", buildZip(t, entries))
+	if job.Status != models.ExtractStatusCompleted {
+		t.Fatalf("解压失败: %s", job.Error)
+	}
+
+	var files []models.File
+	service.db.Where("file_name = ? AND is_folder = ?", "readme.txt", false).Order("id").Find(&files)
+	if len(files) != 2 {
+		t.Fatalf("应解压出2个文件，实际 %d 个", len(files))
+	}
+	if files[0].FilePath == files[1].FilePath {
+		t.Fatalf("同名条目不应共用存储路径: %s", files[0].FilePath)
+	}
+	for i, file := range files {
+		_, reader, err := service.OpenFileForDownload(1, file.ID)
+		if err != nil {
+			t.Fatalf("打开文件失败: %v", err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != entries[i].body {
+			t.Errorf("第 %d 个文件内容为 %q，期望 %q", i+1, data, entries[i].body)
+		}
+	}
+}
+
+// 剩余配额按压缩包本身和已解压的条目计算，条目自身的记录不重复扣除
+func TestExtractQuotaLimit(t *testing.T) {
+	service, storage := newTestExtractService(t)
+	quota := int64(1000)
+	service.db.Model(&models.User{}).Where("id = ?", 1).Update("quota", quota)
+
+	fits := []archiveEntry{{"a.txt", strings.Repeat("a", 600)}}
+	job := extractArchive(t, service, storage, "fits.zip", buildZip(t, fits))
+	if job.Status != models.ExtractStatusCompleted {
+		t.Fatalf("配额足够时应解压成功: %s", job.Error)
+	}
+
+	// 已使用600字节加两个压缩包，剩余不足300字节
+	over := []archiveEntry{{"b.txt", strings.Repeat("b", 300)}}
+	expectExtractFailed(t, extractArchive(t, service, storage, "over.zip", buildZip(t, over)), "存储空间不足")
+}
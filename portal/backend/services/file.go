@@ -1,44 +1,97 @@
 package services
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
+	"mime"
 	"mime/multipart"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"go-auth-server/config"
 	"go-auth-server/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FileService struct {
-	db *gorm.DB
+	db            *gorm.DB
+	storages      *StorageRegistry
+	config        *config.Config
+	mergeJobs     chan uint
+	mergeProgress sync.Map // 文件ID -> 已合并的分片数
+	extractJobs   chan uint
+	quotaLocks    sync.Map // 用户ID -> *sync.Mutex
+	chunkLocksMu  sync.Mutex
+	chunkLocks    map[chunkKey]*chunkLock
+	events        *EventHub
 }
 
-func NewFileService(db *gorm.DB) *FileService {
-	return &FileService{db: db}
+func NewFileService(db *gorm.DB, storages *StorageRegistry, cfg *config.Config) *FileService {
+	return &FileService{
+		db:          db,
+		storages:    storages,
+		config:      cfg,
+		mergeJobs:   make(chan uint, 100),
+		extractJobs: make(chan uint, 100),
+		chunkLocks:  make(map[chunkKey]*chunkLock),
+		events:      NewEventHub(),
+	}
 }
 
 // 创建文件记录
 func (s *FileService) CreateFile(userID uint, req *models.FileUploadRequest) (*models.File, error) {
+	if err := s.checkParentFolder(s.db, userID, req.ParentID); err != nil {
+		return nil, err
+	}
+
 	// 检查文件名是否已存在
 	var existingFile models.File
-	query := s.db.Where("user_id = ? AND file_name = ? AND parent_id = ? AND deleted_at IS NULL",
-		userID, req.FileName, req.ParentID)
+	query := whereParent(s.db.Where("user_id = ? AND file_name = ? AND deleted_at IS NULL",
+		userID, req.FileName), req.ParentID)
 
 	if err := query.First(&existingFile).Error; err == nil {
 		return nil, fmt.Errorf("文件名已存在")
 	}
 
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = s.config.ChunkSize
+	}
+	if err := s.validateChunking(req.FileSize, chunkSize, req.ChunkCount); err != nil {
+		return nil, err
+	}
+
+	// 创建记录即按声明的大小预留配额
+	unlock := s.lockQuota(userID)
+	defer unlock()
+	if err := s.checkQuota(s.db, userID, req.FileSize); err != nil {
+		return nil, err
+	}
+
+	targetID, err := s.resolveSFTPTarget(req.StorageType, req.SFTPTarget)
+	if err != nil {
+		return nil, err
+	}
+	storage, err := s.storageForTarget(req.StorageType, targetID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 生成文件路径
-	filePath := s.generateFilePath(userID, req.FileName, req.StorageType)
+	filePath := storage.GenerateFilePath(userID, req.FileName)
 
 	file := &models.File{
 		UserID:         userID,
@@ -46,29 +99,149 @@ func (s *FileService) CreateFile(userID uint, req *models.FileUploadRequest) (*m
 		OriginalName:   req.FileName,
 		FilePath:       filePath,
 		FileSize:       req.FileSize,
-		MimeType:       req.MimeType,
+		MimeType:       mime.TypeByExtension(strings.ToLower(filepath.Ext(req.FileName))), // 不信任客户端声明的类型，上传第一个分片后按内容确定
 		StorageType:    req.StorageType,
+		SFTPTargetID:   targetID,
 		Status:         models.FileStatusUploading,
 		ParentID:       req.ParentID,
 		IsFolder:       false,
-		Hash:           req.Hash,
+		Hash:           strings.ToLower(req.Hash),
 		ChunkCount:     req.ChunkCount,
+		ChunkSize:      chunkSize,
 		UploadedChunks: "[]", // 初始化为空数组
 	}
 
+	// 秒传：已有相同内容的文件时直接引用其存储数据，无需上传分片。
+	// 查找和创建记录在同一事务中，与彻底删除来源记录的事务互斥，不会引用已被删除的数据
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		source := s.findStoredFileByHash(tx, file)
+		if source == nil {
+			return nil
+		}
+		// 文件名可能与来源不同，按新的扩展名重新检查内容
+		mimeType, mismatch, err := s.recheckStoredType(source, file.FileName)
+		if err != nil {
+			return err
+		}
+		file.FilePath = source.FilePath
+		file.MimeType = mimeType
+		file.TypeMismatch = mismatch
+		file.Status = models.FileStatusCompleted
+		if err := tx.Create(file).Error; err != nil {
+			return fmt.Errorf("创建文件记录失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if file.Status == models.FileStatusCompleted {
+		s.publishEvent(file, models.FileEventCompleted, models.FileEvent{})
+		return file, nil
+	}
+
+	initializer, initialized := storage.(UploadInitializer)
+	if initialized {
+		if err := initializer.InitUpload(file); err != nil {
+			return nil, err
+		}
+	}
 	if err := s.db.Create(file).Error; err != nil {
+		// 没有记录就不会再被清理，中止已创建的上传会话
+		if initialized {
+			storage.DeleteChunks(file)
+		}
 		return nil, fmt.Errorf("创建文件记录失败: %v", err)
 	}
 
 	return file, nil
 }
 
+// 查找同一用户内容相同且已上传完成的文件，用于秒传。已完成文件的Hash在合并时校验过。
+// 知道哈希和大小并不能证明持有文件内容，因此不在不同用户之间共享数据
+func (s *FileService) findStoredFileByHash(db *gorm.DB, file *models.File) *models.File {
+	if file.Hash == "" {
+		return nil
+	}
+
+	var source models.File
+	query := whereSFTPTarget(db.Where("user_id = ? AND hash = ? AND file_size = ? AND storage_type = ? AND status = ? AND is_folder = false",
+		file.UserID, file.Hash, file.FileSize, file.StorageType, models.FileStatusCompleted), file.SFTPTargetID)
+	err := query.First(&source).Error
+	if err != nil {
+		return nil
+	}
+	return &source
+}
+
+// 释放记录对应的存储数据，仍有其他记录引用同一份数据时保留。
+// 必须在删除记录的同一事务中调用：事务持有写锁，统计引用到删除数据之间不会插入新的引用
+func (s *FileService) releaseStoredFile(tx *gorm.DB, file *models.File) error {
+	if file.IsFolder || file.FilePath == "" {
+		return nil
+	}
+
+	var refs int64
+	query := whereSFTPTarget(tx.Model(&models.File{}).
+		Where("storage_type = ? AND file_path = ? AND is_folder = false", file.StorageType, file.FilePath), file.SFTPTargetID)
+	if err := query.Count(&refs).Error; err != nil {
+		return fmt.Errorf("统计文件引用失败: %v", err)
+	}
+	if refs > 0 {
+		return nil
+	}
+
+	storage, err := s.storageFor(file)
+	if err != nil {
+		return err
+	}
+	if err := storage.DeleteFile(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// 查找可续传的上传记录，返回记录及尚未上传的分片序号
+func (s *FileService) FindResumableUpload(userID uint, req *models.ResumeUploadRequest) (*models.ResumeUploadResponse, error) {
+	var file models.File
+	query := whereParent(s.db.Where("user_id = ? AND file_name = ? AND hash = ? AND status = ? AND is_folder = false AND deleted_at IS NULL",
+		userID, req.FileName, strings.ToLower(req.Hash), models.FileStatusUploading), req.ParentID)
+
+	if err := query.Order("updated_at DESC").First(&file).Error; err != nil {
+		return nil, fmt.Errorf("未找到可续传的上传记录")
+	}
+
+	var uploadedChunks []int
+	s.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Pluck("chunk_index", &uploadedChunks)
+
+	uploaded := make(map[int]bool, len(uploadedChunks))
+	for _, chunk := range uploadedChunks {
+		uploaded[chunk] = true
+	}
+
+	missingChunks := []int{}
+	for i := 0; i < file.ChunkCount; i++ {
+		if !uploaded[i] {
+			missingChunks = append(missingChunks, i)
+		}
+	}
+
+	return &models.ResumeUploadResponse{
+		File:          file,
+		MissingChunks: missingChunks,
+	}, nil
+}
+
 // 创建文件夹
 func (s *FileService) CreateFolder(userID uint, req *models.CreateFolderRequest) (*models.File, error) {
+	if err := s.checkParentFolder(s.db, userID, req.ParentID); err != nil {
+		return nil, err
+	}
+
 	// 检查文件夹名是否已存在
 	var existingFolder models.File
-	query := s.db.Where("user_id = ? AND file_name = ? AND parent_id = ? AND is_folder = true AND deleted_at IS NULL",
-		userID, req.FolderName, req.ParentID)
+	query := whereParent(s.db.Where("user_id = ? AND file_name = ? AND is_folder = true AND deleted_at IS NULL",
+		userID, req.FolderName), req.ParentID)
 
 	if err := query.First(&existingFolder).Error; err == nil {
 		return nil, fmt.Errorf("文件夹名已存在")
@@ -94,146 +267,244 @@ func (s *FileService) CreateFolder(userID uint, req *models.CreateFolderRequest)
 	return folder, nil
 }
 
-// 上传文件分片
-func (s *FileService) UploadChunk(fileID uint, chunkIndex int, chunkData string) error {
+// 上传Base64编码的文件分片
+func (s *FileService) UploadChunk(userID uint, fileID uint, chunkIndex int, chunkData string, checksum models.ChunkChecksum) error {
+	// 解码分片数据
+	data, err := base64.StdEncoding.DecodeString(chunkData)
+	if err != nil {
+		return fmt.Errorf("分片数据解码失败: %v", err)
+	}
+
+	return s.UploadChunkStream(userID, fileID, chunkIndex, bytes.NewReader(data), checksum)
+}
+
+// 上传文件分片，分片数据直接流式写入存储后端
+func (s *FileService) UploadChunkStream(userID uint, fileID uint, chunkIndex int, data io.Reader, checksum models.ChunkChecksum) error {
 	var file models.File
-	if err := s.db.First(&file, fileID).Error; err != nil {
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
 		return fmt.Errorf("文件不存在")
 	}
 
-	// 解析已上传的分片
-	var uploadedChunks []int
-	if err := json.Unmarshal([]byte(file.UploadedChunks), &uploadedChunks); err != nil {
-		uploadedChunks = []int{}
-	}
+	// 同一分片的重传等待前一次上传结束，避免在校验通过前覆盖已记录的分片
+	unlock := s.lockChunk(file.ID, chunkIndex)
+	defer unlock()
 
 	// 检查分片是否已上传
-	for _, chunk := range uploadedChunks {
-		if chunk == chunkIndex {
-			return nil // 分片已存在，跳过
-		}
+	var existing int64
+	s.db.Model(&models.FileChunk{}).Where("file_id = ? AND chunk_index = ?", file.ID, chunkIndex).Count(&existing)
+	if existing > 0 {
+		return nil // 分片已存在，跳过
 	}
 
-	// 解码分片数据
-	data, err := base64.StdEncoding.DecodeString(chunkData)
-	if err != nil {
-		return fmt.Errorf("分片数据解码失败: %v", err)
+	if file.Status != models.FileStatusUploading {
+		return fmt.Errorf("文件不在上传状态")
+	}
+	if chunkIndex < 0 || chunkIndex >= file.ChunkCount {
+		return fmt.Errorf("分片序号 %d 超出范围，分片数量为 %d", chunkIndex, file.ChunkCount)
 	}
 
-	// 根据存储类型保存分片
-	switch file.StorageType {
-	case models.StorageLocal:
-		if err := s.saveChunkToLocal(file, chunkIndex, data); err != nil {
+	// 写入时同步计算摘要，校验通过后才记录分片
+	var hasher hash.Hash
+	if checksum.Value != "" {
+		var err error
+		if hasher, err = newHasher(checksum.Algorithm); err != nil {
 			return err
 		}
-	case models.StorageSFTP:
-		if err := s.saveChunkToSFTP(file, chunkIndex, data); err != nil {
+		data = io.TeeReader(data, hasher)
+	}
+	// 第一个分片用于检测文件的实际类型
+	var sniff *sniffBuffer
+	if chunkIndex == 0 {
+		sniff = &sniffBuffer{}
+		data = io.TeeReader(data, sniff)
+	}
+	// 分片大小必须与声明的一致。没有记录分片大小的旧记录只检查不超过创建时预留的大小
+	var limit int64
+	if file.ChunkSize > 0 {
+		limit = expectedChunkSize(&file, chunkIndex)
+	} else {
+		var uploadedSize int64
+		s.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Select("COALESCE(SUM(size), 0)").Scan(&uploadedSize)
+		limit = file.FileSize - uploadedSize
+	}
+	counter := &countingReader{reader: io.LimitReader(data, limit+1)}
+
+	// 保存分片到存储后端
+	storage, err := s.storageFor(&file)
+	if err != nil {
+		return err
+	}
+	if err := storage.SaveChunk(&file, chunkIndex, counter); err != nil {
+		return err
+	}
+
+	if counter.n > limit {
+		return fmt.Errorf("分片 %d 超出文件声明的大小", chunkIndex)
+	}
+	if file.ChunkSize > 0 && counter.n != limit {
+		return fmt.Errorf("分片 %d 大小应为 %d 字节，实际为 %d 字节", chunkIndex, limit, counter.n)
+	}
+
+	// 校验失败的分片不记录，客户端重传时会覆盖
+	if hasher != nil && !strings.EqualFold(hex.EncodeToString(hasher.Sum(nil)), checksum.Value) {
+		return fmt.Errorf("分片 %d 校验失败", chunkIndex)
+	}
+
+	if sniff != nil {
+		if err := s.applySniffedType(&file, sniff.buf); err != nil {
 			return err
 		}
-	default:
-		return fmt.Errorf("不支持的存储类型")
 	}
 
-	// 更新已上传分片列表
-	uploadedChunks = append(uploadedChunks, chunkIndex)
-	chunksJSON, _ := json.Marshal(uploadedChunks)
+	if err := s.recordChunk(&file, chunkIndex, counter.n); err != nil {
+		return err
+	}
 
-	// 更新文件记录
-	updates := map[string]interface{}{
-		"uploaded_chunks": string(chunksJSON),
+	uploaded, total, err := s.chunkStats(file.ID)
+	if err != nil {
+		return fmt.Errorf("统计已上传分片失败: %v", err)
+	}
+	s.publishEvent(&file, models.FileEventChunkAccepted, models.FileEvent{
+		ChunkIndex:     &chunkIndex,
+		UploadedChunks: uploaded,
+		UploadedSize:   total,
+	})
+
+	// 所有分片都已记录时，通过条件更新抢占合并，并发到达的最后几个分片只会触发一次合并
+	if uploaded < file.ChunkCount {
+		return nil
 	}
 
-	// 如果所有分片都上传完成，合并文件
-	if len(uploadedChunks) == file.ChunkCount {
-		if err := s.mergeChunks(&file); err != nil {
-			return fmt.Errorf("合并分片失败: %v", err)
+	if total != file.FileSize {
+		reason := fmt.Sprintf("上传的数据大小 %d 与文件大小 %d 不一致", total, file.FileSize)
+		result := s.db.Model(&models.File{}).Where("id = ? AND status = ?", file.ID, models.FileStatusUploading).
+			Updates(map[string]interface{}{"status": models.FileStatusFailed, "fail_reason": reason})
+		if result.RowsAffected > 0 {
+			s.publishEvent(&file, models.FileEventFailed, models.FileEvent{Error: reason})
 		}
-		updates["status"] = models.FileStatusCompleted
+		return fmt.Errorf("%s", reason)
 	}
 
-	return s.db.Model(&file).Updates(updates).Error
+	result := s.db.Model(&models.File{}).
+		Where("id = ? AND status = ?", file.ID, models.FileStatusUploading).
+		Update("status", models.FileStatusMerging)
+	if result.Error != nil {
+		return fmt.Errorf("更新文件状态失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil // 其他请求已开始合并
+	}
+	s.publishEvent(&file, models.FileEventMergeStarted, models.FileEvent{})
+
+	// 合并交给后台任务，避免大文件合并阻塞上传最后一个分片的请求
+	s.enqueueMerge(file.ID)
+	return nil
 }
 
-// 保存分片到本地
-func (s *FileService) saveChunkToLocal(file models.File, chunkIndex int, data []byte) error {
-	chunkDir := filepath.Join("uploads", "chunks", fmt.Sprintf("%d", file.ID))
-	if err := os.MkdirAll(chunkDir, 0755); err != nil {
-		return fmt.Errorf("创建分片目录失败: %v", err)
-	}
+type chunkKey struct {
+	fileID     uint
+	chunkIndex int
+}
 
-	chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", chunkIndex))
-	return os.WriteFile(chunkPath, data, 0644)
+type chunkLock struct {
+	mu   sync.Mutex
+	refs int
 }
 
-// 保存分片到SFTP
-func (s *FileService) saveChunkToSFTP(file models.File, chunkIndex int, data []byte) error {
-	// 获取SFTP配置
-	sftpConfig, err := s.getSFTPConfig()
-	if err != nil {
-		return fmt.Errorf("获取SFTP配置失败: %v", err)
+// 按文件和分片序号加锁，锁不再被使用时移除
+func (s *FileService) lockChunk(fileID uint, chunkIndex int) func() {
+	key := chunkKey{fileID: fileID, chunkIndex: chunkIndex}
+
+	s.chunkLocksMu.Lock()
+	lock, ok := s.chunkLocks[key]
+	if !ok {
+		lock = &chunkLock{}
+		s.chunkLocks[key] = lock
 	}
+	lock.refs++
+	s.chunkLocksMu.Unlock()
 
-	sftpService := NewSFTPService(sftpConfig)
-	return sftpService.SaveChunk(file, chunkIndex, data)
-}
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
 
-// 合并分片
-func (s *FileService) mergeChunks(file *models.File) error {
-	switch file.StorageType {
-	case models.StorageLocal:
-		return s.mergeLocalChunks(file)
-	case models.StorageSFTP:
-		return s.mergeSFTPChunks(file)
-	default:
-		return fmt.Errorf("不支持的存储类型")
+		s.chunkLocksMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(s.chunkLocks, key)
+		}
+		s.chunkLocksMu.Unlock()
 	}
 }
 
-// 合并本地分片
-func (s *FileService) mergeLocalChunks(file *models.File) error {
-	// 确保目标目录存在
-	targetDir := filepath.Dir(file.FilePath)
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("创建目标目录失败: %v", err)
-	}
+// 记录已上传的分片，并同步更新uploaded_chunks字段以兼容旧客户端
+func (s *FileService) recordChunk(file *models.File, chunkIndex int, size int64) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		chunk := &models.FileChunk{FileID: file.ID, ChunkIndex: chunkIndex, Size: size}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(chunk).Error; err != nil {
+			return err
+		}
+
+		// 并发上传的分片可能同时通过大小检查，在事务内复核
+		var total int64
+		if err := tx.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Select("COALESCE(SUM(size), 0)").Scan(&total).Error; err != nil {
+			return err
+		}
+		if total > file.FileSize {
+			return fmt.Errorf("分片总大小超出文件声明的大小")
+		}
 
-	// 创建目标文件
-	targetFile, err := os.Create(file.FilePath)
+		return tx.Exec(`UPDATE files SET updated_at = ?, uploaded_chunks = (
+			SELECT json_group_array(chunk_index) FROM (
+				SELECT chunk_index FROM file_chunks WHERE file_id = ? ORDER BY chunk_index
+			)
+		) WHERE id = ?`, time.Now(), file.ID, file.ID).Error
+	})
 	if err != nil {
-		return fmt.Errorf("创建目标文件失败: %v", err)
+		return fmt.Errorf("记录分片失败: %v", err)
 	}
-	defer targetFile.Close()
+	return nil
+}
 
-	// 按顺序合并分片
-	chunkDir := filepath.Join("uploads", "chunks", fmt.Sprintf("%d", file.ID))
-	for i := 0; i < file.ChunkCount; i++ {
-		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", i))
-		chunkData, err := os.ReadFile(chunkPath)
-		if err != nil {
-			return fmt.Errorf("读取分片 %d 失败: %v", i, err)
-		}
+// 校验存储中的文件与File.Hash是否一致，Hash为空时跳过
+func (s *FileService) verifyFileHash(storage Storage, file *models.File) error {
+	if file.Hash == "" {
+		return nil
+	}
 
-		if _, err := targetFile.Write(chunkData); err != nil {
-			return fmt.Errorf("写入分片 %d 失败: %v", i, err)
-		}
+	// Hash按长度区分算法：32位为MD5，64位为SHA-256
+	algorithm := "md5"
+	if len(file.Hash) == sha256.Size*2 {
+		algorithm = "sha256"
+	}
+	hasher, _ := newHasher(algorithm)
+
+	reader, err := storage.OpenFile(file)
+	if err != nil {
+		return fmt.Errorf("读取合并后的文件失败: %v", err)
 	}
+	defer reader.Close()
 
-	// 清理分片文件
-	os.RemoveAll(chunkDir)
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return fmt.Errorf("读取合并后的文件失败: %v", err)
+	}
 
+	if actual := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(actual, file.Hash) {
+		return fmt.Errorf("文件校验失败: 期望 %s，实际 %s", file.Hash, actual)
+	}
 	return nil
 }
 
-// 合并SFTP分片
-func (s *FileService) mergeSFTPChunks(file *models.File) error {
-	// 获取SFTP配置
-	sftpConfig, err := s.getSFTPConfig()
-	if err != nil {
-		return fmt.Errorf("获取SFTP配置失败: %v", err)
+// 根据算法名创建摘要计算器
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "", "md5":
+		return md5.New(), nil
+	case "sha256", "sha-256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("不支持的校验算法: %s", algorithm)
 	}
-
-	sftpService := NewSFTPService(sftpConfig)
-	return sftpService.MergeChunks(file)
 }
 
 // 获取文件列表
@@ -283,14 +554,33 @@ func (s *FileService) GetFileList(userID uint, req *models.FileListRequest) (*mo
 
 // 删除文件
 func (s *FileService) DeleteFile(userID uint, fileID uint) error {
+	return s.deleteFile(s.db, userID, fileID)
+}
+
+func (s *FileService) deleteFile(db *gorm.DB, userID uint, fileID uint) error {
 	var file models.File
-	if err := s.db.Where("id = ? AND user_id = ?", fileID, userID).First(&file).Error; err != nil {
+	if err := db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
 		return fmt.Errorf("文件不存在")
 	}
 
-	// 软删除
+	// 软删除，文件进入回收站。文件夹连同其中未删除的内容使用相同的删除时间，恢复时据此一起恢复
 	now := time.Now()
-	return s.db.Model(&file).Update("deleted_at", now).Error
+	if !file.IsFolder {
+		return db.Model(&file).Update("deleted_at", now).Error
+	}
+
+	nodes, err := s.loadSubtree(db, userID, file.ID)
+	if err != nil {
+		return err
+	}
+	ids := make([]uint, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+
+	return db.Model(&models.File{}).
+		Where("id IN ? AND deleted_at IS NULL", ids).
+		Update("deleted_at", now).Error
 }
 
 // 重命名文件
@@ -302,78 +592,219 @@ func (s *FileService) RenameFile(userID uint, req *models.RenameFileRequest) err
 
 	// 检查新名称是否已存在
 	var existingFile models.File
-	query := s.db.Where("user_id = ? AND file_name = ? AND parent_id = ? AND id != ? AND deleted_at IS NULL",
-		userID, req.NewName, file.ParentID, req.FileID)
+	query := whereParent(s.db.Where("user_id = ? AND file_name = ? AND id != ? AND deleted_at IS NULL",
+		userID, req.NewName, req.FileID), file.ParentID)
 
 	if err := query.First(&existingFile).Error; err == nil {
 		return fmt.Errorf("文件名已存在")
 	}
 
+	// 新名称同样要符合文件类型策略，不能通过重命名绕过
+	if !file.IsFolder {
+		if err := s.ValidateFileType(s.userRole(userID), file.StorageType, req.NewName); err != nil {
+			return err
+		}
+	}
+
 	// 更新文件名
 	updates := map[string]interface{}{
 		"file_name":     req.NewName,
 		"original_name": req.NewName,
 	}
 
+	// 扩展名变化时按新的扩展名重新检查已上传完成的文件内容
+	if !file.IsFolder && file.Status == models.FileStatusCompleted &&
+		!strings.EqualFold(filepath.Ext(file.FileName), filepath.Ext(req.NewName)) {
+		mimeType, mismatch, err := s.recheckStoredType(&file, req.NewName)
+		if err != nil {
+			return err
+		}
+		updates["mime_type"] = mimeType
+		updates["type_mismatch"] = mismatch
+	}
+
 	return s.db.Model(&file).Updates(updates).Error
 }
 
 // 移动文件
 func (s *FileService) MoveFile(userID uint, req *models.MoveFileRequest) error {
+	return s.moveFile(s.db, userID, req.FileID, req.ParentID)
+}
+
+func (s *FileService) moveFile(db *gorm.DB, userID uint, fileID uint, parentID *uint) error {
 	var file models.File
-	if err := s.db.Where("id = ? AND user_id = ?", req.FileID, userID).First(&file).Error; err != nil {
+	if err := db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
 		return fmt.Errorf("文件不存在")
 	}
 
+	if err := s.checkParentFolder(db, userID, parentID); err != nil {
+		return err
+	}
+	if err := s.checkNotDescendant(db, userID, &file, parentID); err != nil {
+		return err
+	}
+
 	// 检查目标位置是否已存在同名文件
 	var existingFile models.File
-	query := s.db.Where("user_id = ? AND file_name = ? AND parent_id = ? AND id != ? AND deleted_at IS NULL",
-		userID, file.FileName, req.ParentID, req.FileID)
+	query := whereParent(db.Where("user_id = ? AND file_name = ? AND id != ? AND deleted_at IS NULL",
+		userID, file.FileName, fileID), parentID)
 
 	if err := query.First(&existingFile).Error; err == nil {
 		return fmt.Errorf("目标位置已存在同名文件")
 	}
 
 	// 更新父目录
-	return s.db.Model(&file).Update("parent_id", req.ParentID).Error
+	return db.Model(&file).Update("parent_id", parentID).Error
 }
 
-// 生成文件路径
-func (s *FileService) generateFilePath(userID uint, fileName string, storageType models.StorageType) string {
-	timestamp := time.Now().Unix()
-	hash := md5.Sum([]byte(fmt.Sprintf("%d_%s_%d", userID, fileName, timestamp)))
-	hashStr := fmt.Sprintf("%x", hash)
+// 打开文件用于下载，调用方负责关闭返回的读取器
+func (s *FileService) OpenFileForDownload(userID uint, fileID uint) (*models.File, io.ReadSeekCloser, error) {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+		return nil, nil, fmt.Errorf("文件不存在")
+	}
 
-	switch storageType {
-	case models.StorageLocal:
-		return filepath.Join("uploads", "files", fmt.Sprintf("%d", userID), hashStr[:2], hashStr[2:4], fileName)
-	case models.StorageSFTP:
-		return fmt.Sprintf("/uploads/%d/%s/%s/%s", userID, hashStr[:2], hashStr[2:4], fileName)
-	default:
-		return ""
+	if file.IsFolder {
+		return nil, nil, fmt.Errorf("不能下载文件夹")
+	}
+	if file.Status != models.FileStatusCompleted {
+		return nil, nil, fmt.Errorf("文件尚未上传完成")
+	}
+
+	storage, err := s.storageFor(&file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := storage.OpenFile(&file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &file, reader, nil
+}
+
+// 获取文件详情，包括存储后端中的实际信息和所在路径
+func (s *FileService) GetFileInfo(userID uint, fileID uint) (*models.FileInfoResponse, error) {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+		return nil, fmt.Errorf("文件不存在")
+	}
+
+	path, err := s.getFolderPath(userID, file.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &models.FileInfoResponse{
+		File: file,
+		Path: path,
+	}
+
+	if !file.IsFolder {
+		info.Storage = s.statStoredFile(file)
+	}
+
+	return info, nil
+}
+
+// 获取文件夹从根目录开始的完整路径，通过递归查询一次取出所有祖先
+func (s *FileService) getFolderPath(userID uint, parentID *uint) ([]models.PathItem, error) {
+	path := []models.PathItem{}
+	if parentID == nil {
+		return path, nil
+	}
+
+	// depth限制用于防止异常数据形成环时无限递归
+	err := s.db.Raw(`
+		WITH RECURSIVE ancestors(id, file_name, parent_id, depth) AS (
+			SELECT id, file_name, parent_id, 0 FROM files WHERE id = ? AND user_id = ?
+			UNION ALL
+			SELECT f.id, f.file_name, f.parent_id, a.depth + 1
+			FROM files f JOIN ancestors a ON f.id = a.parent_id
+			WHERE f.user_id = ? AND a.depth < 100
+		)
+		SELECT id, file_name FROM ancestors ORDER BY depth DESC`,
+		*parentID, userID, userID).Scan(&path).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取文件路径失败: %v", err)
 	}
+
+	return path, nil
+}
+
+// 查询存储后端中文件的实际状态
+func (s *FileService) statStoredFile(file models.File) *models.StorageFileInfo {
+	var fileInfo os.FileInfo
+	storage, err := s.storageFor(&file)
+	if err == nil {
+		fileInfo, err = storage.GetFileInfo(&file)
+	}
+
+	info := &models.StorageFileInfo{}
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			info.Error = err.Error()
+		}
+		return info
+	}
+
+	modTime := fileInfo.ModTime()
+	info.Exists = true
+	info.Size = fileInfo.Size()
+	info.ModTime = &modTime
+	info.SizeMatch = fileInfo.Size() == file.FileSize
+	return info
 }
 
 // 获取文件上传进度
-func (s *FileService) GetUploadProgress(fileID uint) (*models.UploadProgress, error) {
+func (s *FileService) GetUploadProgress(userID uint, fileID uint) (*models.UploadProgress, error) {
 	var file models.File
-	if err := s.db.First(&file, fileID).Error; err != nil {
+	if err := s.db.Where("id = ? AND user_id = ?", fileID, userID).First(&file).Error; err != nil {
 		return nil, fmt.Errorf("文件不存在")
 	}
 
-	var uploadedChunks []int
-	json.Unmarshal([]byte(file.UploadedChunks), &uploadedChunks)
+	// 秒传的文件没有分片记录
+	if file.Status == models.FileStatusCompleted || file.ChunkCount == 0 {
+		return &models.UploadProgress{
+			FileID:       file.ID,
+			FileName:     file.FileName,
+			TotalSize:    file.FileSize,
+			UploadedSize: file.FileSize,
+			Progress:     100,
+			Status:       file.Status,
+		}, nil
+	}
 
-	uploadedSize := int64(len(uploadedChunks)) * (file.FileSize / int64(file.ChunkCount))
-	progress := float64(len(uploadedChunks)) / float64(file.ChunkCount) * 100
+	if file.Status == models.FileStatusMerging {
+		mergeProgress := 0.0
+		if merged, ok := s.mergeProgress.Load(file.ID); ok {
+			mergeProgress = float64(merged.(int)) / float64(file.ChunkCount) * 100
+		}
+		return &models.UploadProgress{
+			FileID:        file.ID,
+			FileName:      file.FileName,
+			TotalSize:     file.FileSize,
+			UploadedSize:  file.FileSize,
+			Progress:      100,
+			MergeProgress: mergeProgress,
+			Status:        file.Status,
+		}, nil
+	}
+
+	// 与事件流使用同一统计，按已记录分片的实际字节数计算
+	uploadedChunks, uploadedSize, err := s.chunkStats(file.ID)
+	if err != nil {
+		return nil, fmt.Errorf("统计已上传分片失败: %v", err)
+	}
 
 	return &models.UploadProgress{
 		FileID:       file.ID,
 		FileName:     file.FileName,
 		TotalSize:    file.FileSize,
 		UploadedSize: uploadedSize,
-		Progress:     progress,
+		Progress:     float64(uploadedChunks) / float64(file.ChunkCount) * 100,
 		Status:       file.Status,
+		FailReason:   file.FailReason,
 	}, nil
 }
 
@@ -386,60 +817,87 @@ func (s *FileService) CalculateFileHash(file multipart.File) (string, error) {
 	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
-// 验证文件类型
-func (s *FileService) ValidateFileType(fileName string) error {
-	ext := strings.ToLower(filepath.Ext(fileName))
+// 获取文件大小限制
+func (s *FileService) GetFileSizeLimit() int64 {
+	return s.config.MaxFileSize
+}
 
-	// 允许的文件类型
-	allowedExts := []string{
-		".txt", ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
-		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".svg",
-		".mp4", ".avi", ".mov", ".wmv", ".flv",
-		".mp3", ".wav", ".flac", ".aac",
-		".zip", ".rar", ".7z", ".tar", ".gz",
-	}
+// 获取默认分片大小
+func (s *FileService) GetChunkSize() int64 {
+	return s.config.ChunkSize
+}
 
-	for _, allowedExt := range allowedExts {
-		if ext == allowedExt {
-			return nil
-		}
+// 获取允许的最大分片大小
+func (s *FileService) GetMaxChunkSize() int64 {
+	return s.config.MaxChunkSize
+}
+
+// 获取上传限制，前端据此切分文件
+func (s *FileService) GetUploadLimits(role string) *models.UploadLimits {
+	allowedTypes := s.config.FileTypes.Default.Allow
+	if rule, ok := s.config.FileTypes.Roles[role]; ok && rule.Allow != nil {
+		allowedTypes = rule.Allow
 	}
 
-	return fmt.Errorf("不支持的文件类型: %s", ext)
+	return &models.UploadLimits{
+		MaxFileSize:  s.config.MaxFileSize,
+		ChunkSize:    s.config.ChunkSize,
+		MaxChunkSize: s.config.MaxChunkSize,
+		MinChunkSize: map[models.StorageType]int64{models.StorageS3: s3MinPartSize},
+		AllowedTypes: allowedTypes,
+		SFTPTargets:  s.sftpTargetNames(),
+	}
 }
 
-// 获取文件大小限制
-func (s *FileService) GetFileSizeLimit() int64 {
-	// 默认100MB
-	return 100 * 1024 * 1024
-}
+// 校验分片参数，分片数量必须与按分片大小切分文件的结果一致
+func (s *FileService) validateChunking(fileSize int64, chunkSize int64, chunkCount int) error {
+	if fileSize < 0 {
+		return fmt.Errorf("文件大小无效")
+	}
+	if chunkSize <= 0 || chunkSize > s.config.MaxChunkSize {
+		return fmt.Errorf("分片大小必须在 1 到 %d 字节之间", s.config.MaxChunkSize)
+	}
 
-// 获取分片大小
-func (s *FileService) GetChunkSize() int64 {
-	// 默认2MB
-	return 2 * 1024 * 1024
+	// 空文件也需要上传一个空分片
+	expected := int((fileSize + chunkSize - 1) / chunkSize)
+	if expected == 0 {
+		expected = 1
+	}
+	if chunkCount != expected {
+		return fmt.Errorf("分片数量应为 %d，实际为 %d", expected, chunkCount)
+	}
+	return nil
 }
 
-// 获取SFTP配置
-func (s *FileService) getSFTPConfig() (*models.SFTPConfig, error) {
-	// 从环境变量加载SFTP配置
-	sftpConfig := config.LoadSFTPConfig()
-
-	// 验证配置
-	if err := config.ValidateSFTPConfig(sftpConfig); err != nil {
-		return nil, err
+// 计算分片应有的大小，最后一个分片可以小于分片大小
+func expectedChunkSize(file *models.File, chunkIndex int) int64 {
+	if chunkIndex < file.ChunkCount-1 {
+		return file.ChunkSize
 	}
+	return file.FileSize - file.ChunkSize*int64(file.ChunkCount-1)
+}
 
-	return sftpConfig, nil
+// SFTP连接池统计
+func (s *FileService) GetSFTPPoolStats() []models.SFTPPoolStats {
+	return SFTPPoolStats()
 }
 
-// 测试SFTP连接
-func (s *FileService) TestSFTPConnection() error {
-	config, err := s.getSFTPConfig()
-	if err != nil {
-		return err
+// 父目录条件，parentID为nil时匹配根目录
+func whereParent(query *gorm.DB, parentID *uint) *gorm.DB {
+	if parentID == nil {
+		return query.Where("parent_id IS NULL")
 	}
+	return query.Where("parent_id = ?", *parentID)
+}
+
+// 统计读取字节数的Reader
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
 
-	sftpService := NewSFTPService(config)
-	return sftpService.TestConnection()
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
 }
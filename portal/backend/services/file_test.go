@@ -0,0 +1,223 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-auth-server/config"
+	"go-auth-server/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const storageMemory models.StorageType = "memory"
+
+// 内存存储，用于测试
+type memoryStorage struct {
+	mu     sync.Mutex
+	chunks map[uint]map[int][]byte
+	files  map[string][]byte
+	merges int32
+	paths  int64
+	// 设置后DeleteChunks返回该错误，模拟存储中的分片无法删除
+	deleteChunksErr error
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		chunks: make(map[uint]map[int][]byte),
+		files:  make(map[string][]byte),
+	}
+}
+
+func (m *memoryStorage) GenerateFilePath(userID uint, fileName string) string {
+	return fmt.Sprintf("%d/%d/%s", userID, atomic.AddInt64(&m.paths, 1), fileName)
+}
+
+func (m *memoryStorage) SaveChunk(file *models.File, chunkIndex int, data io.Reader) error {
+	buf, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.chunks[file.ID] == nil {
+		m.chunks[file.ID] = make(map[int][]byte)
+	}
+	m.chunks[file.ID][chunkIndex] = buf
+	return nil
+}
+
+func (m *memoryStorage) MergeChunks(file *models.File, progress func(merged int)) error {
+	atomic.AddInt32(&m.merges, 1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var merged bytes.Buffer
+	for i := 0; i < file.ChunkCount; i++ {
+		chunk, ok := m.chunks[file.ID][i]
+		if !ok {
+			return fmt.Errorf("分片 %d 不存在", i)
+		}
+		merged.Write(chunk)
+		progress(i + 1)
+	}
+	m.files[file.FilePath] = merged.Bytes()
+	delete(m.chunks, file.ID)
+	return nil
+}
+
+func (m *memoryStorage) DeleteChunks(file *models.File) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deleteChunksErr != nil {
+		return m.deleteChunksErr
+	}
+	delete(m.chunks, file.ID)
+	return nil
+}
+
+func (m *memoryStorage) OpenFile(file *models.File) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[file.FilePath]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (m *memoryStorage) GetFileInfo(file *models.File) (os.FileInfo, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (m *memoryStorage) DeleteFile(file *models.File) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, file.FilePath)
+	return nil
+}
+
+func (m *memoryStorage) ListDirectory(path string) ([]os.FileInfo, error) {
+	return nil, nil
+}
+
+// 支持随机读取，便于解压zip
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+func newTestFileService(t *testing.T) (*FileService, *memoryStorage) {
+	t.Helper()
+
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.File{}, &models.FileChunk{}); err != nil {
+		t.Fatalf("迁移数据库失败: %v", err)
+	}
+	if err := db.Create(&models.User{ID: 1, Username: "test", Password: "-", Role: models.RoleUser}).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+
+	storage := newMemoryStorage()
+	storages := NewStorageRegistry()
+	storages.Register(storageMemory, storage)
+	return NewFileService(db, storages, config.LoadConfig()), storage
+}
+
+// 等待后台合并结束
+func waitForMerge(t *testing.T, service *FileService, fileID uint) models.File {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var file models.File
+		service.db.First(&file, fileID)
+		if file.Status != models.FileStatusMerging && file.Status != models.FileStatusUploading {
+			return file
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待合并超时，当前状态 %s", file.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 多个goroutine并发上传分片（每个分片上传两次），合并只能发生一次且不丢分片
+func TestUploadChunkStreamConcurrent(t *testing.T) {
+	service, storage := newTestFileService(t)
+	service.StartMergeWorkers(2)
+
+	const chunkCount = 32
+	const chunkSize = 1024
+	content := make([]byte, chunkCount*chunkSize)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	sum := md5.Sum(content)
+
+	file, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    "race.bin",
+		FileSize:    int64(len(content)),
+		Hash:        hex.EncodeToString(sum[:]),
+		ChunkCount:  chunkCount,
+		ChunkSize:   chunkSize,
+		StorageType: storageMemory,
+	})
+	if err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, chunkCount*2)
+	for i := 0; i < chunkCount*2; i++ {
+		wg.Add(1)
+		go func(chunkIndex int) {
+			defer wg.Done()
+			chunk := content[chunkIndex*chunkSize : (chunkIndex+1)*chunkSize]
+			if err := service.UploadChunkStream(1, file.ID, chunkIndex, bytes.NewReader(chunk), models.ChunkChecksum{}); err != nil {
+				errs <- fmt.Errorf("分片 %d: %v", chunkIndex, err)
+			}
+		}(i % chunkCount)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	stored := waitForMerge(t, service, file.ID)
+	if merges := atomic.LoadInt32(&storage.merges); merges != 1 {
+		t.Errorf("合并次数 = %d，期望 1", merges)
+	}
+
+	var chunks int64
+	service.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Count(&chunks)
+	if chunks != chunkCount {
+		t.Errorf("分片记录数 = %d，期望 %d", chunks, chunkCount)
+	}
+
+	if stored.Status != models.FileStatusCompleted {
+		t.Errorf("文件状态 = %s，期望 %s（%s）", stored.Status, models.FileStatusCompleted, stored.FailReason)
+	}
+	if !bytes.Equal(storage.files[stored.FilePath], content) {
+		t.Error("合并后的文件内容不一致")
+	}
+}
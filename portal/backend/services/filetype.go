@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"go-auth-server/config"
+	"go-auth-server/models"
+)
+
+// 内容检测所需的字节数，与http.DetectContentType一致
+const sniffLen = 512
+
+// 检测结果明确的类型及其对应的扩展名，检测为这些类型而扩展名不在其中时视为不符
+var sniffedTypeExts = map[string][]string{
+	"image/jpeg":                   {".jpg", ".jpeg"},
+	"image/png":                    {".png"},
+	"image/gif":                    {".gif"},
+	"image/bmp":                    {".bmp"},
+	"image/webp":                   {".webp"},
+	"application/pdf":              {".pdf"},
+	"application/zip":              {".zip", ".docx", ".xlsx", ".pptx"},
+	"application/x-gzip":           {".gz", ".tgz"},
+	"application/x-rar-compressed": {".rar"},
+	"text/html; charset=utf-8":     {".html", ".htm"},
+	"text/xml; charset=utf-8":      {".xml", ".svg"},
+	"video/mp4":                    {".mp4", ".mov"},
+	"video/avi":                    {".avi"},
+	"audio/wave":                   {".wav"},
+	"audio/mpeg":                   {".mp3"},
+}
+
+// 有固定文件头的扩展名，内容检测结果必须与之一致
+var signatureExts = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".bmp":  "image/bmp",
+	".pdf":  "application/pdf",
+	".zip":  "application/zip",
+	".docx": "application/zip",
+	".xlsx": "application/zip",
+	".pptx": "application/zip",
+	".gz":   "application/x-gzip",
+	".rar":  "application/x-rar-compressed",
+}
+
+// 按配置的策略检查文件类型是否允许上传
+func (s *FileService) ValidateFileType(role string, storageType models.StorageType, fileName string) error {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	policy := s.config.FileTypes
+
+	allow := policy.Default.Allow
+	roleRule, hasRoleRule := policy.Roles[role]
+	if hasRoleRule && roleRule.Allow != nil {
+		allow = roleRule.Allow
+	}
+	if !matchExt(allow, ext) {
+		return fmt.Errorf("不支持的文件类型: %s", ext)
+	}
+
+	storageRule, hasStorageRule := policy.Storages[string(storageType)]
+	if hasStorageRule && storageRule.Allow != nil && !matchExt(storageRule.Allow, ext) {
+		return fmt.Errorf("%s存储不支持的文件类型: %s", storageType, ext)
+	}
+
+	for _, deny := range [][]string{policy.Default.Deny, roleRule.Deny, storageRule.Deny} {
+		if matchExt(deny, ext) {
+			return fmt.Errorf("不允许上传的文件类型: %s", ext)
+		}
+	}
+	return nil
+}
+
+func matchExt(exts []string, ext string) bool {
+	for _, e := range exts {
+		if e == "*" || e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// 根据文件开头的内容检测实际类型，返回应记录的MIME类型以及是否与扩展名不符
+func detectFileType(fileName string, head []byte) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	detected := http.DetectContentType(head)
+
+	mismatch := false
+	if exts, ok := sniffedTypeExts[detected]; ok && !matchExt(exts, ext) {
+		mismatch = true
+	}
+	if want, ok := signatureExts[ext]; ok && detected != want {
+		mismatch = true
+	}
+
+	// 无法识别的二进制和纯文本按扩展名细化类型
+	mimeType := detected
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		if byExt := mime.TypeByExtension(ext); byExt != "" && !mismatch {
+			mimeType = byExt
+		}
+	}
+	return mimeType, mismatch
+}
+
+// 用第一个分片的内容确定文件的MIME类型，与扩展名不符时按策略拒绝或标记
+func (s *FileService) applySniffedType(file *models.File, head []byte) error {
+	mimeType, mismatch := detectFileType(file.FileName, head)
+	updates := map[string]interface{}{"mime_type": mimeType}
+
+	if mismatch {
+		reason := fmt.Sprintf("文件内容与扩展名不符，检测到的类型为 %s", mimeType)
+		if s.config.FileTypes.MismatchAction != config.MismatchFlag {
+			s.db.Model(file).Updates(map[string]interface{}{
+				"status":      models.FileStatusFailed,
+				"fail_reason": reason,
+			})
+			s.cleanupChunks(file)
+			s.publishEvent(file, models.FileEventFailed, models.FileEvent{Error: reason})
+			return fmt.Errorf("%s", reason)
+		}
+		updates["type_mismatch"] = true
+	}
+
+	if err := s.db.Model(file).Updates(updates).Error; err != nil {
+		return fmt.Errorf("更新文件类型失败: %v", err)
+	}
+	file.MimeType = mimeType
+	file.TypeMismatch = mismatch
+	return nil
+}
+
+// 读取已存储文件开头的内容，按fileName的扩展名重新检测类型，用于秒传和修改扩展名的重命名。
+// 内容与扩展名不符且策略为拒绝时返回错误
+func (s *FileService) recheckStoredType(stored *models.File, fileName string) (string, bool, error) {
+	storage, err := s.storageFor(stored)
+	if err != nil {
+		return "", false, err
+	}
+	reader, err := storage.OpenFile(stored)
+	if err != nil {
+		return "", false, err
+	}
+	defer reader.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", false, fmt.Errorf("读取文件内容失败: %v", err)
+	}
+
+	mimeType, mismatch := detectFileType(fileName, head[:n])
+	if mismatch && s.config.FileTypes.MismatchAction != config.MismatchFlag {
+		return "", false, fmt.Errorf("文件内容与扩展名不符，检测到的类型为 %s", mimeType)
+	}
+	return mimeType, mismatch, nil
+}
+
+// 获取用户角色
+func (s *FileService) userRole(userID uint) string {
+	var roles []string
+	s.db.Model(&models.User{}).Where("id = ?", userID).Pluck("role", &roles)
+	if len(roles) == 0 {
+		return ""
+	}
+	return roles[0]
+}
+
+// 保存数据流开头的字节用于内容检测
+type sniffBuffer struct {
+	buf []byte
+}
+
+func (b *sniffBuffer) Write(p []byte) (int, error) {
+	if remaining := sniffLen - len(b.buf); remaining > 0 {
+		if len(p) > remaining {
+			b.buf = append(b.buf, p[:remaining]...)
+		} else {
+			b.buf = append(b.buf, p...)
+		}
+	}
+	return len(p), nil
+}
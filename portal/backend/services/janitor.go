@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"go-auth-server/models"
+)
+
+// 定期清理过期的上传和回收站，UploadJanitorInterval为0时不启动
+func (s *FileService) StartJanitor() {
+	if s.config == nil || s.config.UploadJanitorInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.config.UploadJanitorInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			result := s.CleanupStaleUploads()
+			if len(result.ExpiredFiles) > 0 || len(result.CleanedFiles) > 0 {
+				log.Printf("清理过期上传: 过期 %d 个, 清理 %d 个, 释放 %d 字节",
+					len(result.ExpiredFiles), len(result.CleanedFiles), result.ReclaimedBytes)
+			}
+			for _, msg := range result.Errors {
+				log.Printf("清理过期上传失败: %s", msg)
+			}
+
+			purged, errs := s.PurgeExpiredTrash()
+			if purged > 0 {
+				log.Printf("清理回收站: 彻底删除 %d 个文件", purged)
+			}
+			for _, msg := range errs {
+				log.Printf("清理回收站失败: %s", msg)
+			}
+		}
+	}()
+}
+
+// 将超过UploadTTL没有新分片的上传标记为失败，并清理这些文件以及长期未重试的失败文件的分片数据
+func (s *FileService) CleanupStaleUploads() *models.UploadCleanupResult {
+	result := &models.UploadCleanupResult{ExpiredFiles: []uint{}, CleanedFiles: []uint{}}
+	cutoff := time.Now().Add(-s.config.UploadTTL)
+
+	// 标记过期的上传，条件更新避免与正在完成的上传竞争
+	var stale []models.File
+	s.db.Where("status = ? AND is_folder = ? AND updated_at < ?", models.FileStatusUploading, false, cutoff).Find(&stale)
+	for _, file := range stale {
+		res := s.db.Model(&models.File{}).
+			Where("id = ? AND status = ? AND updated_at < ?", file.ID, models.FileStatusUploading, cutoff).
+			Updates(map[string]interface{}{
+				"status":      models.FileStatusFailed,
+				"fail_reason": "上传超时",
+			})
+		if res.Error != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("文件 %d: %v", file.ID, res.Error))
+			continue
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		result.ExpiredFiles = append(result.ExpiredFiles, file.ID)
+		s.cleanupFileChunks(result, &file)
+		s.publishEvent(&file, models.FileEventFailed, models.FileEvent{Error: "上传超时"})
+	}
+
+	// 合并失败且超过UploadTTL未重试的文件，分片数据不再保留
+	var failed []models.File
+	s.db.Where("status = ? AND updated_at < ? AND id IN (?)", models.FileStatusFailed, cutoff,
+		s.db.Model(&models.FileChunk{}).Select("file_id")).Find(&failed)
+	for _, file := range failed {
+		s.cleanupFileChunks(result, &file)
+	}
+
+	return result
+}
+
+func (s *FileService) cleanupFileChunks(result *models.UploadCleanupResult, file *models.File) {
+	reclaimed, err := s.cleanupChunks(file)
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("文件 %d: %v", file.ID, err))
+		return
+	}
+	result.CleanedFiles = append(result.CleanedFiles, file.ID)
+	result.ReclaimedBytes += reclaimed
+}
+
+// 删除文件的分片数据和分片记录，返回释放的字节数
+func (s *FileService) cleanupChunks(file *models.File) (int64, error) {
+	storage, err := s.storageFor(file)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	s.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Select("COALESCE(SUM(size), 0)").Scan(&size)
+
+	if err := storage.DeleteChunks(file); err != nil {
+		return 0, err
+	}
+	if err := s.db.Where("file_id = ?", file.ID).Delete(&models.FileChunk{}).Error; err != nil {
+		return 0, fmt.Errorf("删除分片记录失败: %v", err)
+	}
+	s.db.Model(file).Update("uploaded_chunks", "[]")
+
+	return size, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go-auth-server/models"
+)
+
+// 创建一个只上传了第一个分片的文件，并将其最后更新时间设置为UploadTTL之前
+func createStaleUpload(t *testing.T, service *FileService, fileName string) *models.File {
+	t.Helper()
+
+	file, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    fileName,
+		FileSize:    200,
+		ChunkCount:  2,
+		ChunkSize:   100,
+		StorageType: storageMemory,
+	})
+	if err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	if err := service.UploadChunkStream(1, file.ID, 0, bytes.NewReader(make([]byte, 100)), models.ChunkChecksum{}); err != nil {
+		t.Fatalf("上传分片失败: %v", err)
+	}
+
+	expired := time.Now().Add(-service.config.UploadTTL - time.Minute)
+	service.db.Model(&models.File{}).Where("id = ?", file.ID).UpdateColumn("updated_at", expired)
+	return file
+}
+
+func TestCleanupStaleUploads(t *testing.T) {
+	service, storage := newTestFileService(t)
+	file := createStaleUpload(t, service, "stale.bin")
+	if _, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName: "fresh.bin", FileSize: 100, ChunkCount: 1, ChunkSize: 100, StorageType: storageMemory,
+	}); err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+
+	result := service.CleanupStaleUploads()
+	if len(result.Errors) != 0 {
+		t.Fatalf("清理不应出错: %v", result.Errors)
+	}
+	if len(result.ExpiredFiles) != 1 || result.ExpiredFiles[0] != file.ID {
+		t.Fatalf("只有过期的上传应标记为失败，实际 %v", result.ExpiredFiles)
+	}
+	if len(result.CleanedFiles) != 1 || result.CleanedFiles[0] != file.ID || result.ReclaimedBytes != 100 {
+		t.Fatalf("清理结果不正确: %+v", result)
+	}
+
+	var stored models.File
+	service.db.First(&stored, file.ID)
+	if stored.Status != models.FileStatusFailed || stored.FailReason != "上传超时" {
+		t.Fatalf("过期的上传应标记为失败，实际 %s %q", stored.Status, stored.FailReason)
+	}
+	var chunks int64
+	service.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Count(&chunks)
+	if chunks != 0 || len(storage.chunks[file.ID]) != 0 {
+		t.Fatalf("分片记录和分片数据应被删除")
+	}
+
+	// 再次运行不会重复清理
+	if again := service.CleanupStaleUploads(); len(again.ExpiredFiles) != 0 || len(again.CleanedFiles) != 0 {
+		t.Fatalf("不应重复清理: %+v", again)
+	}
+}
+
+// 存储中的分片无法删除时报告错误，不计入已清理，之后可以再次清理
+func TestCleanupStaleUploadsReportsStorageErrors(t *testing.T) {
+	service, storage := newTestFileService(t)
+	file := createStaleUpload(t, service, "stale.bin")
+	storage.deleteChunksErr = errors.New("存储不可用")
+
+	result := service.CleanupStaleUploads()
+	if len(result.ExpiredFiles) != 1 || len(result.CleanedFiles) != 0 || result.ReclaimedBytes != 0 {
+		t.Fatalf("删除分片失败时不应计入已清理: %+v", result)
+	}
+	if len(result.Errors) != 1 {
+		t.Fatalf("应报告删除分片失败，实际 %v", result.Errors)
+	}
+
+	// 失败文件的分片记录保留，存储恢复后由失败文件的清理删除
+	storage.deleteChunksErr = nil
+	service.db.Model(&models.File{}).Where("id = ?", file.ID).
+		UpdateColumn("updated_at", time.Now().Add(-service.config.UploadTTL-time.Minute))
+	result = service.CleanupStaleUploads()
+	if len(result.CleanedFiles) != 1 || result.CleanedFiles[0] != file.ID || result.ReclaimedBytes != 100 {
+		t.Fatalf("存储恢复后应清理分片: %+v", result)
+	}
+}
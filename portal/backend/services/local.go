@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go-auth-server/models"
+)
+
+// 本地磁盘存储
+type LocalStorage struct {
+	basePath string
+}
+
+func NewLocalStorage(basePath string) *LocalStorage {
+	return &LocalStorage{basePath: basePath}
+}
+
+// 生成文件存储路径
+func (s *LocalStorage) GenerateFilePath(userID uint, fileName string) string {
+	hashStr := pathHash(userID, fileName)
+	return filepath.Join(s.basePath, "files", fmt.Sprintf("%d", userID), hashStr[:2], hashStr[2:4], hashStr[4:], fileName)
+}
+
+// 分片目录
+func (s *LocalStorage) chunkDir(file *models.File) string {
+	return filepath.Join(s.basePath, "chunks", fmt.Sprintf("%d", file.ID))
+}
+
+// 保存分片
+func (s *LocalStorage) SaveChunk(file *models.File, chunkIndex int, data io.Reader) error {
+	chunkDir := s.chunkDir(file)
+	if err := os.MkdirAll(chunkDir, 0755); err != nil {
+		return fmt.Errorf("创建分片目录失败: %v", err)
+	}
+
+	chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", chunkIndex))
+	chunkFile, err := os.Create(chunkPath)
+	if err != nil {
+		return fmt.Errorf("创建分片文件失败: %v", err)
+	}
+	defer chunkFile.Close()
+
+	if _, err := io.Copy(chunkFile, data); err != nil {
+		return fmt.Errorf("写入分片数据失败: %v", err)
+	}
+
+	return nil
+}
+
+// 合并分片
+func (s *LocalStorage) MergeChunks(file *models.File, progress func(merged int)) error {
+	// 确保目标目录存在
+	targetDir := filepath.Dir(file.FilePath)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("创建目标目录失败: %v", err)
+	}
+
+	// 创建目标文件
+	targetFile, err := os.Create(file.FilePath)
+	if err != nil {
+		return fmt.Errorf("创建目标文件失败: %v", err)
+	}
+	defer targetFile.Close()
+
+	// 按顺序合并分片
+	chunkDir := s.chunkDir(file)
+	for i := 0; i < file.ChunkCount; i++ {
+		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", i))
+		chunkFile, err := os.Open(chunkPath)
+		if err != nil {
+			return fmt.Errorf("读取分片 %d 失败: %v", i, err)
+		}
+
+		_, err = io.Copy(targetFile, chunkFile)
+		chunkFile.Close()
+		if err != nil {
+			return fmt.Errorf("写入分片 %d 失败: %v", i, err)
+		}
+		progress(i + 1)
+	}
+
+	// 清理分片文件
+	os.RemoveAll(chunkDir)
+
+	return nil
+}
+
+// 删除分片
+func (s *LocalStorage) DeleteChunks(file *models.File) error {
+	if err := os.RemoveAll(s.chunkDir(file)); err != nil {
+		return fmt.Errorf("删除分片失败: %v", err)
+	}
+	return nil
+}
+
+// 打开文件
+func (s *LocalStorage) OpenFile(file *models.File) (io.ReadSeekCloser, error) {
+	localFile, err := os.Open(file.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开本地文件失败: %v", err)
+	}
+	return localFile, nil
+}
+
+// 获取文件信息
+func (s *LocalStorage) GetFileInfo(file *models.File) (os.FileInfo, error) {
+	return os.Stat(file.FilePath)
+}
+
+// 删除文件
+func (s *LocalStorage) DeleteFile(file *models.File) error {
+	if err := os.Remove(file.FilePath); err != nil {
+		return fmt.Errorf("删除文件失败: %v", err)
+	}
+
+	// 尝试删除空目录
+	s.cleanupEmptyDirectories(filepath.Dir(file.FilePath))
+
+	return nil
+}
+
+// 列出目录内容
+func (s *LocalStorage) ListDirectory(path string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // 读取期间已被删除
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// 清理空目录，不会越过基础路径
+func (s *LocalStorage) cleanupEmptyDirectories(dirPath string) {
+	for dirPath != "." && dirPath != "/" && dirPath != s.basePath {
+		if err := os.Remove(dirPath); err != nil {
+			return // 目录非空或无法删除
+		}
+		dirPath = filepath.Dir(dirPath)
+	}
+}
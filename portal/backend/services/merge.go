@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"go-auth-server/models"
+)
+
+// 启动合并任务工作池，并恢复服务重启前未完成的合并
+func (s *FileService) StartMergeWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		go s.mergeWorker()
+	}
+
+	var fileIDs []uint
+	s.db.Model(&models.File{}).Where("status = ?", models.FileStatusMerging).Pluck("id", &fileIDs)
+	for _, fileID := range fileIDs {
+		s.enqueueMerge(fileID)
+	}
+}
+
+// 提交合并任务，队列满时不阻塞调用方
+func (s *FileService) enqueueMerge(fileID uint) {
+	select {
+	case s.mergeJobs <- fileID:
+	default:
+		go func() { s.mergeJobs <- fileID }()
+	}
+}
+
+func (s *FileService) mergeWorker() {
+	for fileID := range s.mergeJobs {
+		var file models.File
+		if err := s.db.First(&file, fileID).Error; err != nil || file.Status != models.FileStatusMerging {
+			continue
+		}
+
+		if err := s.mergeFile(&file); err != nil {
+			log.Printf("合并文件 %d 失败: %v", file.ID, err)
+		}
+	}
+}
+
+// 合并分片并校验，完成后更新文件状态
+func (s *FileService) mergeFile(file *models.File) error {
+	defer s.mergeProgress.Delete(file.ID)
+
+	fail := func(err error) error {
+		s.db.Model(file).Updates(map[string]interface{}{
+			"status":      models.FileStatusFailed,
+			"fail_reason": err.Error(),
+		})
+		s.publishEvent(file, models.FileEventFailed, models.FileEvent{Error: err.Error()})
+		return err
+	}
+
+	storage, err := s.storageFor(file)
+	if err != nil {
+		return fail(err)
+	}
+
+	s.mergeProgress.Store(file.ID, 0)
+	err = storage.MergeChunks(file, func(merged int) {
+		s.mergeProgress.Store(file.ID, merged)
+		s.publishEvent(file, models.FileEventMergeProgress, models.FileEvent{MergedChunks: merged})
+	})
+	if err != nil {
+		// 分片仍保留在存储中，可以重试合并
+		return fail(fmt.Errorf("合并分片失败: %v", err))
+	}
+
+	// 校验合并后的文件摘要，不一致时不标记为完成。此时分片已被清理，无法重试合并，
+	// 删除分片记录和内容有误的合并结果，记录重置为上传中，客户端可以通过续传重新上传全部分片
+	if err := s.verifyFileHash(storage, file); err != nil {
+		s.db.Where("file_id = ?", file.ID).Delete(&models.FileChunk{})
+		if delErr := storage.DeleteFile(file); delErr != nil {
+			log.Printf("删除校验失败的文件 %d 失败: %v", file.ID, delErr)
+		}
+		return s.restartUpload(storage, file, err, fail)
+	}
+
+	if err := s.db.Model(file).Updates(map[string]interface{}{
+		"status":      models.FileStatusCompleted,
+		"fail_reason": "",
+	}).Error; err != nil {
+		return err
+	}
+	s.publishEvent(file, models.FileEventCompleted, models.FileEvent{})
+	return nil
+}
+
+// 将校验失败的文件重置为上传中，失败原因保留到重新上传完成。
+// 需要上传会话的存储后端在合并时已结束会话，重新创建；创建失败时只能标记为失败
+func (s *FileService) restartUpload(storage Storage, file *models.File, cause error, fail func(error) error) error {
+	updates := map[string]interface{}{
+		"status":          models.FileStatusUploading,
+		"uploaded_chunks": "[]",
+		"fail_reason":     cause.Error(),
+	}
+	if initializer, ok := storage.(UploadInitializer); ok {
+		if err := initializer.InitUpload(file); err != nil {
+			return fail(fmt.Errorf("%v，重新创建上传会话失败: %v", cause, err))
+		}
+		updates["upload_id"] = file.UploadID
+	}
+
+	if err := s.db.Model(file).Updates(updates).Error; err != nil {
+		return fail(cause)
+	}
+	s.publishEvent(file, models.FileEventFailed, models.FileEvent{Error: cause.Error()})
+	return cause
+}
+
+// 重新合并失败的文件，已上传的分片无需重新上传
+func (s *FileService) RetryMerge(userID uint, fileID uint) error {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+		return fmt.Errorf("文件不存在")
+	}
+
+	if file.Status != models.FileStatusFailed {
+		return fmt.Errorf("只有合并失败的文件可以重试")
+	}
+
+	var uploaded int64
+	s.db.Model(&models.FileChunk{}).Where("file_id = ?", file.ID).Count(&uploaded)
+	if int(uploaded) < file.ChunkCount {
+		return fmt.Errorf("分片不完整，请重新上传文件")
+	}
+
+	result := s.db.Model(&models.File{}).
+		Where("id = ? AND status = ?", file.ID, models.FileStatusFailed).
+		Updates(map[string]interface{}{
+			"status":      models.FileStatusMerging,
+			"fail_reason": "",
+		})
+	if result.Error != nil {
+		return fmt.Errorf("更新文件状态失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("文件状态已变化，请刷新后重试")
+	}
+	s.publishEvent(&file, models.FileEventMergeStarted, models.FileEvent{})
+
+	s.enqueueMerge(file.ID)
+	return nil
+}
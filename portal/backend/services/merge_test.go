@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"go-auth-server/models"
+)
+
+// 等待合并结束。合并失败时记录可能重置为上传中，不能用waitForMerge等待
+func waitWhileMerging(t *testing.T, service *FileService, fileID uint) models.File {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var file models.File
+		service.db.First(&file, fileID)
+		if file.Status != models.FileStatusMerging {
+			return file
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待合并超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 合并后摘要不一致时记录重置为上传中，可以续传重新上传全部分片并完成
+func TestMergeHashMismatchRestartsUpload(t *testing.T) {
+	for _, storageType := range []models.StorageType{storageMemory, models.StorageS3} {
+		t.Run(string(storageType), func(t *testing.T) {
+			service, s3 := newTestS3Service(t)
+			service.StartMergeWorkers(1)
+
+			content := []byte("the expected content of the file")
+			corrupted := bytes.ToUpper(content)
+			sum := md5.Sum(content)
+			req := &models.FileUploadRequest{
+				FileName:    "a.txt",
+				FileSize:    int64(len(content)),
+				Hash:        hex.EncodeToString(sum[:]),
+				ChunkCount:  1,
+				ChunkSize:   int64(len(content)),
+				StorageType: storageType,
+			}
+			file, err := service.CreateFile(1, req)
+			if err != nil {
+				t.Fatalf("创建文件失败: %v", err)
+			}
+
+			if err := service.UploadChunkStream(1, file.ID, 0, bytes.NewReader(corrupted), models.ChunkChecksum{}); err != nil {
+				t.Fatalf("上传分片失败: %v", err)
+			}
+			stored := waitWhileMerging(t, service, file.ID)
+			if stored.Status != models.FileStatusUploading || stored.FailReason == "" {
+				t.Fatalf("校验失败后应重置为上传中并保留原因，实际 %s %q", stored.Status, stored.FailReason)
+			}
+
+			resume, err := service.FindResumableUpload(1, &models.ResumeUploadRequest{FileName: req.FileName, Hash: req.Hash})
+			if err != nil {
+				t.Fatalf("校验失败的文件应可以续传: %v", err)
+			}
+			if resume.File.ID != file.ID || len(resume.MissingChunks) != 1 {
+				t.Fatalf("续传应重新上传全部分片，实际缺少 %v", resume.MissingChunks)
+			}
+
+			if err := service.UploadChunkStream(1, file.ID, 0, bytes.NewReader(content), models.ChunkChecksum{}); err != nil {
+				t.Fatalf("重新上传分片失败: %v", err)
+			}
+			stored = waitForMerge(t, service, file.ID)
+			if stored.Status != models.FileStatusCompleted || stored.FailReason != "" {
+				t.Fatalf("重新上传后应完成，实际 %s %q", stored.Status, stored.FailReason)
+			}
+
+			_, reader, err := service.OpenFileForDownload(1, file.ID)
+			if err != nil {
+				t.Fatalf("打开文件失败: %v", err)
+			}
+			defer reader.Close()
+			var data bytes.Buffer
+			data.ReadFrom(reader)
+			if !bytes.Equal(data.Bytes(), content) {
+				t.Fatalf("文件内容应为重新上传的内容，实际 %q", data.Bytes())
+			}
+			if n := s3.openUploads(); n != 0 {
+				t.Fatalf("不应遗留multipart upload，实际 %d 个", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"go-auth-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 计入配额的文件状态，上传中和合并中的文件按声明的大小预留空间
+var quotaStatuses = []models.FileStatus{
+	models.FileStatusUploading,
+	models.FileStatusMerging,
+	models.FileStatusCompleted,
+}
+
+// 获取用户的有效配额，limited为false表示不限制。用户未单独设置时使用角色的默认配额，
+// 角色的默认配额<=0表示不限制；用户单独设置的配额为0表示不能再占用空间
+func (s *FileService) userQuota(db *gorm.DB, userID uint) (quota int64, limited bool, err error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return 0, false, fmt.Errorf("用户不存在")
+	}
+
+	switch {
+	case user.Unlimited:
+		return 0, false, nil
+	case user.Quota != nil:
+		return *user.Quota, true, nil
+	}
+	quota = s.config.RoleQuotas[string(user.Role)]
+	return quota, quota > 0, nil
+}
+
+// 统计用户已使用的空间，回收站中的文件在彻底删除前仍占用配额。
+// 秒传和复制的文件虽然共享存储数据，仍按各自的大小计算
+func (s *FileService) usedBytes(db *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	err := db.Model(&models.File{}).
+		Where("user_id = ? AND is_folder = ? AND status IN ?", userID, false, quotaStatuses).
+		Select("COALESCE(SUM(file_size), 0)").Scan(&used).Error
+	if err != nil {
+		return 0, fmt.Errorf("统计已用空间失败: %v", err)
+	}
+	return used, nil
+}
+
+// 检查新增size字节后是否超出配额
+func (s *FileService) checkQuota(db *gorm.DB, userID uint, size int64) error {
+	quota, limited, err := s.userQuota(db, userID)
+	if err != nil || !limited {
+		return err
+	}
+
+	used, err := s.usedBytes(db, userID)
+	if err != nil {
+		return err
+	}
+	if used+size > quota {
+		return fmt.Errorf("存储空间不足：已使用 %d 字节，配额 %d 字节", used, quota)
+	}
+	return nil
+}
+
+// 获取用户剩余配额，不限制时返回-1
+func (s *FileService) remainingQuota(db *gorm.DB, userID uint) (int64, error) {
+	quota, limited, err := s.userQuota(db, userID)
+	if err != nil || !limited {
+		return -1, err
+	}
+
+	used, err := s.usedBytes(db, userID)
+	if err != nil {
+		return 0, err
+	}
+	if used > quota {
+		return 0, nil
+	}
+	return quota - used, nil
+}
+
+// 按用户加锁，使配额检查和创建文件记录之间不会插入同一用户的其他请求
+func (s *FileService) lockQuota(userID uint) func() {
+	lock, _ := s.quotaLocks.LoadOrStore(userID, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// 获取用户的空间使用情况
+func (s *FileService) GetUsage(userID uint) (*models.StorageUsage, error) {
+	quota, limited, err := s.userQuota(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		StorageType models.StorageType
+		Trashed     bool
+		Bytes       int64
+		Files       int64
+	}
+	err = s.db.Model(&models.File{}).
+		Select("storage_type, deleted_at IS NOT NULL AS trashed, COALESCE(SUM(file_size), 0) AS bytes, COUNT(*) AS files").
+		Where("user_id = ? AND is_folder = ? AND status IN ?", userID, false, quotaStatuses).
+		Group("storage_type, trashed").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("统计已用空间失败: %v", err)
+	}
+
+	usage := &models.StorageUsage{
+		Quota:     quota,
+		Unlimited: !limited,
+		ByStorage: make(map[models.StorageType]int64),
+	}
+	for _, row := range rows {
+		usage.Used += row.Bytes
+		usage.ByStorage[row.StorageType] += row.Bytes
+		if row.Trashed {
+			usage.TrashBytes += row.Bytes
+		} else {
+			usage.FileCount += row.Files
+		}
+	}
+
+	if limited {
+		remaining := quota - usage.Used
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage.Remaining = &remaining
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-auth-server/models"
+)
+
+func createQuotaTestFile(service *FileService, fileName string, size int64) error {
+	_, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    fileName,
+		FileSize:    size,
+		ChunkCount:  1,
+		ChunkSize:   size,
+		StorageType: storageMemory,
+	})
+	return err
+}
+
+func expectQuotaExceeded(t *testing.T, err error) {
+	t.Helper()
+
+	if err == nil || !strings.Contains(err.Error(), "存储空间不足") {
+		t.Fatalf("超出配额时应拒绝，实际错误: %v", err)
+	}
+}
+
+// 上传中的文件按声明的大小预留配额，超出角色的默认配额时拒绝
+func TestCheckQuotaRejectsOverQuota(t *testing.T) {
+	service, _ := newTestFileService(t)
+	service.config.RoleQuotas[string(models.RoleUser)] = 100
+
+	if err := createQuotaTestFile(service, "a.bin", 60); err != nil {
+		t.Fatalf("配额内创建文件失败: %v", err)
+	}
+	expectQuotaExceeded(t, createQuotaTestFile(service, "b.bin", 60))
+	if err := createQuotaTestFile(service, "c.bin", 40); err != nil {
+		t.Fatalf("恰好用满配额时应允许: %v", err)
+	}
+
+	usage, err := service.GetUsage(1)
+	if err != nil {
+		t.Fatalf("获取使用情况失败: %v", err)
+	}
+	if usage.Quota != 100 || usage.Unlimited || usage.Used != 100 || usage.Remaining == nil || *usage.Remaining != 0 {
+		t.Fatalf("使用情况不正确: %+v", usage)
+	}
+}
+
+// 用户单独设置的配额优先于角色的默认配额，0表示不能再占用空间
+func TestUserQuotaOverride(t *testing.T) {
+	service, _ := newTestFileService(t)
+	service.config.RoleQuotas[string(models.RoleUser)] = 100
+	auth := NewAuthService(service.db)
+
+	quota := int64(1000)
+	if err := auth.UpdateUserQuota(1, &quota, false); err != nil {
+		t.Fatalf("设置配额失败: %v", err)
+	}
+	if err := createQuotaTestFile(service, "a.bin", 600); err != nil {
+		t.Fatalf("单独设置的配额内创建文件失败: %v", err)
+	}
+
+	zero := int64(0)
+	if err := auth.UpdateUserQuota(1, &zero, false); err != nil {
+		t.Fatalf("设置配额失败: %v", err)
+	}
+	expectQuotaExceeded(t, createQuotaTestFile(service, "b.bin", 1))
+
+	if err := auth.UpdateUserQuota(1, nil, true); err != nil {
+		t.Fatalf("设置不限制配额失败: %v", err)
+	}
+	if err := createQuotaTestFile(service, "c.bin", 5000); err != nil {
+		t.Fatalf("不限制配额时创建文件失败: %v", err)
+	}
+	usage, err := service.GetUsage(1)
+	if err != nil {
+		t.Fatalf("获取使用情况失败: %v", err)
+	}
+	if !usage.Unlimited || usage.Remaining != nil {
+		t.Fatalf("不限制配额时使用情况不正确: %+v", usage)
+	}
+
+	// 恢复为角色的默认配额
+	if err := auth.UpdateUserQuota(1, nil, false); err != nil {
+		t.Fatalf("恢复默认配额失败: %v", err)
+	}
+	expectQuotaExceeded(t, createQuotaTestFile(service, "d.bin", 1))
+
+	if err := auth.UpdateUserQuota(1, &quota, true); err == nil {
+		t.Fatalf("同时设置配额和不限制配额应报错")
+	}
+}
+
+// 同一用户并发创建文件时配额检查与创建记录之间不会插入其他请求
+func TestCreateFileConcurrentQuota(t *testing.T) {
+	service, _ := newTestFileService(t)
+	service.config.RoleQuotas[string(models.RoleUser)] = 1000
+
+	const workers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- createQuotaTestFile(service, fmt.Sprintf("%d.bin", i), 300)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !strings.Contains(err.Error(), "存储空间不足") {
+			t.Errorf("意外的错误: %v", err)
+		}
+	}
+	if succeeded != 3 {
+		t.Fatalf("配额1000字节应只能创建3个300字节的文件，实际 %d 个", succeeded)
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go-auth-server/models"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3要求除最后一个分片外每个分片不小于5MB
+const s3MinPartSize = 5 * 1024 * 1024
+
+// S3兼容对象存储，分片直接映射为multipart upload的part，合并即CompleteMultipartUpload
+type S3Storage struct {
+	config *models.S3Config
+	client s3Client
+}
+
+// S3Storage使用的S3操作，测试中可以替换为内存实现
+type s3Client interface {
+	NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.PutObjectOptions) (string, error)
+	PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int,
+		data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error)
+	ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker, maxParts int) (minio.ListObjectPartsResult, error)
+	CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string,
+		parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string) error
+	// 打开对象用于读取，对象不存在时返回错误
+	OpenObject(ctx context.Context, bucket, object string) (io.ReadSeekCloser, error)
+	StatObject(ctx context.Context, bucket, object string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	RemoveObject(ctx context.Context, bucket, object string, opts minio.RemoveObjectOptions) error
+	ListObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+}
+
+// 基于minio-go的s3Client实现。Core的GetObject和ListObjects是底层接口，对象读取和列表使用Client的版本
+type minioClient struct {
+	*minio.Core
+}
+
+func (c minioClient) OpenObject(ctx context.Context, bucket, object string) (io.ReadSeekCloser, error) {
+	obj, err := c.Client.GetObject(ctx, bucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject是延迟请求的，先Stat以便尽早发现对象不存在等错误
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (c minioClient) ListObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	return c.Client.ListObjects(ctx, bucket, opts)
+}
+
+func NewS3Storage(config *models.S3Config) (*S3Storage, error) {
+	client, err := minio.NewCore(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("S3客户端创建失败: %v", err)
+	}
+
+	return &S3Storage{config: config, client: minioClient{client}}, nil
+}
+
+// 生成对象键
+func (s *S3Storage) GenerateFilePath(userID uint, fileName string) string {
+	hashStr := pathHash(userID, fileName)
+	return path.Join(fmt.Sprintf("%d", userID), hashStr[:2], hashStr[2:4], hashStr[4:], fileName)
+}
+
+// 创建multipart upload，会话ID记录在file.UploadID中
+func (s *S3Storage) InitUpload(file *models.File) error {
+	if file.ChunkCount > 1 && file.ChunkSize < s3MinPartSize {
+		return fmt.Errorf("S3存储的分片大小不能小于5MB")
+	}
+
+	uploadID, err := s.client.NewMultipartUpload(context.Background(), s.config.Bucket, file.FilePath, minio.PutObjectOptions{
+		ContentType: file.MimeType,
+	})
+	if err != nil {
+		return fmt.Errorf("创建S3分片上传失败: %v", err)
+	}
+
+	file.UploadID = uploadID
+	return nil
+}
+
+// 上传分片，分片序号从0开始，S3的part编号从1开始
+func (s *S3Storage) SaveChunk(file *models.File, chunkIndex int, data io.Reader) error {
+	if file.UploadID == "" {
+		return fmt.Errorf("S3分片上传会话不存在")
+	}
+
+	// PutObjectPart需要预先知道分片大小，分片大小固定时直接流式上传
+	var size int64
+	sized, hasLen := data.(interface{ Len() int })
+	switch {
+	case file.ChunkSize > 0:
+		size = expectedChunkSize(file, chunkIndex)
+	case hasLen:
+		size = int64(sized.Len())
+	default:
+		// 大小未知时先写入临时文件，避免整个分片留在内存中
+		spool, n, err := spoolToTempFile(data)
+		if err != nil {
+			return fmt.Errorf("读取分片数据失败: %v", err)
+		}
+		defer spool.Close()
+		data, size = spool, n
+	}
+
+	_, err := s.client.PutObjectPart(context.Background(), s.config.Bucket, file.FilePath, file.UploadID,
+		chunkIndex+1, io.LimitReader(data, size), size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return fmt.Errorf("上传S3分片失败: %v", err)
+	}
+
+	// 读取一个字节检查是否还有多余的数据，由调用方按读取的字节数报告分片超长
+	if n, _ := data.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("分片 %d 超出分片大小", chunkIndex)
+	}
+	return nil
+}
+
+// 将数据写入临时文件并定位到开头，关闭时删除临时文件
+func spoolToTempFile(data io.Reader) (*tempFile, int64, error) {
+	f, err := os.CreateTemp("", "s3-part-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	spool := &tempFile{f}
+
+	n, err := io.Copy(f, data)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, 0, err
+	}
+	return spool, n, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// 完成multipart upload
+func (s *S3Storage) MergeChunks(file *models.File, progress func(merged int)) error {
+	ctx := context.Background()
+
+	// 从S3列出已上传的part以获取ETag
+	var parts []minio.CompletePart
+	marker := 0
+	for {
+		result, err := s.client.ListObjectParts(ctx, s.config.Bucket, file.FilePath, file.UploadID, marker, 1000)
+		if err != nil {
+			return fmt.Errorf("获取S3分片列表失败: %v", err)
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, minio.CompletePart{PartNumber: part.PartNumber, ETag: part.ETag})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	if len(parts) != file.ChunkCount {
+		return fmt.Errorf("S3分片数量不完整: %d/%d", len(parts), file.ChunkCount)
+	}
+
+	_, err := s.client.CompleteMultipartUpload(ctx, s.config.Bucket, file.FilePath, file.UploadID, parts, minio.PutObjectOptions{
+		ContentType: file.MimeType,
+	})
+	if err != nil {
+		return fmt.Errorf("完成S3分片上传失败: %v", err)
+	}
+	progress(file.ChunkCount)
+
+	return nil
+}
+
+// 中止multipart upload，S3会丢弃已上传的part
+func (s *S3Storage) DeleteChunks(file *models.File) error {
+	if file.UploadID == "" {
+		return nil
+	}
+
+	err := s.client.AbortMultipartUpload(context.Background(), s.config.Bucket, file.FilePath, file.UploadID)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+		return fmt.Errorf("中止S3分片上传失败: %v", err)
+	}
+	return nil
+}
+
+// 打开对象用于读取，支持Seek
+func (s *S3Storage) OpenFile(file *models.File) (io.ReadSeekCloser, error) {
+	object, err := s.client.OpenObject(context.Background(), s.config.Bucket, file.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("打开S3对象失败: %v", s3Error(file.FilePath, err))
+	}
+	return object, nil
+}
+
+// 获取对象信息
+func (s *S3Storage) GetFileInfo(file *models.File) (os.FileInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.config.Bucket, file.FilePath, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(file.FilePath, err)
+	}
+	return &s3FileInfo{name: path.Base(info.Key), size: info.Size, modTime: info.LastModified}, nil
+}
+
+// 删除对象
+func (s *S3Storage) DeleteFile(file *models.File) error {
+	err := s.client.RemoveObject(context.Background(), s.config.Bucket, file.FilePath, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("删除文件失败: %v", err)
+	}
+	return nil
+}
+
+// 列出前缀下的对象，公共前缀作为目录返回
+func (s *S3Storage) ListDirectory(dirPath string) ([]os.FileInfo, error) {
+	prefix := strings.Trim(dirPath, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var infos []os.FileInfo
+	for object := range s.client.ListObjects(context.Background(), s.config.Bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, prefix)
+		if strings.HasSuffix(name, "/") {
+			infos = append(infos, &s3FileInfo{name: strings.TrimSuffix(name, "/"), isDir: true})
+			continue
+		}
+		infos = append(infos, &s3FileInfo{name: name, size: object.Size, modTime: object.LastModified})
+	}
+	return infos, nil
+}
+
+// 将对象不存在的错误转换为fs.ErrNotExist
+func s3Error(key string, err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// S3对象的os.FileInfo实现
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.isDir }
+func (fi *s3FileInfo) Sys() any           { return nil }
+
+func (fi *s3FileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
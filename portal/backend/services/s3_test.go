@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-auth-server/models"
+
+	"github.com/minio/minio-go/v7"
+	"gorm.io/gorm"
+)
+
+// 内存中的S3实现，按S3的规则校验multipart upload
+type fakeS3 struct {
+	mu      sync.Mutex
+	nextID  int
+	uploads map[string]*fakeS3Upload
+	objects map[string][]byte
+}
+
+type fakeS3Upload struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		uploads: make(map[string]*fakeS3Upload),
+		objects: make(map[string][]byte),
+	}
+}
+
+func (f *fakeS3) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.PutObjectOptions) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	uploadID := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[uploadID] = &fakeS3Upload{key: object, parts: make(map[int][]byte)}
+	return uploadID, nil
+}
+
+func (f *fakeS3) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int,
+	data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error) {
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(data, buf); err != nil {
+		return minio.ObjectPart{}, fmt.Errorf("读取part数据失败: %v", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[uploadID]
+	if !ok || upload.key != object {
+		return minio.ObjectPart{}, minio.ErrorResponse{Code: "NoSuchUpload"}
+	}
+	upload.parts[partID] = buf
+	return minio.ObjectPart{PartNumber: partID, ETag: partETag(buf), Size: size}, nil
+}
+
+func (f *fakeS3) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker, maxParts int) (minio.ListObjectPartsResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[uploadID]
+	if !ok {
+		return minio.ListObjectPartsResult{}, minio.ErrorResponse{Code: "NoSuchUpload"}
+	}
+
+	var result minio.ListObjectPartsResult
+	for partID, data := range upload.parts {
+		if partID > partNumberMarker {
+			result.ObjectParts = append(result.ObjectParts, minio.ObjectPart{PartNumber: partID, ETag: partETag(data), Size: int64(len(data))})
+		}
+	}
+	sort.Slice(result.ObjectParts, func(i, j int) bool {
+		return result.ObjectParts[i].PartNumber < result.ObjectParts[j].PartNumber
+	})
+	if len(result.ObjectParts) > maxParts {
+		result.ObjectParts = result.ObjectParts[:maxParts]
+		result.IsTruncated = true
+		result.NextPartNumberMarker = result.ObjectParts[maxParts-1].PartNumber
+	}
+	return result, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string,
+	parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	upload, ok := f.uploads[uploadID]
+	if !ok {
+		return minio.UploadInfo{}, minio.ErrorResponse{Code: "NoSuchUpload"}
+	}
+
+	var merged bytes.Buffer
+	for i, part := range parts {
+		data, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != partETag(data) {
+			return minio.UploadInfo{}, minio.ErrorResponse{Code: "InvalidPart"}
+		}
+		if i < len(parts)-1 && len(data) < s3MinPartSize {
+			return minio.UploadInfo{}, minio.ErrorResponse{Code: "EntityTooSmall"}
+		}
+		merged.Write(data)
+	}
+	f.objects[object] = merged.Bytes()
+	delete(f.uploads, uploadID)
+	return minio.UploadInfo{Key: object, Size: int64(merged.Len())}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.uploads[uploadID]; !ok {
+		return minio.ErrorResponse{Code: "NoSuchUpload"}
+	}
+	delete(f.uploads, uploadID)
+	return nil
+}
+
+func (f *fakeS3) OpenObject(ctx context.Context, bucket, object string) (io.ReadSeekCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[object]
+	if !ok {
+		return nil, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (f *fakeS3) StatObject(ctx context.Context, bucket, object string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[object]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+	return minio.ObjectInfo{Key: object, Size: int64(len(data))}, nil
+}
+
+func (f *fakeS3) RemoveObject(ctx context.Context, bucket, object string, opts minio.RemoveObjectOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, object)
+	return nil
+}
+
+func (f *fakeS3) ListObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan minio.ObjectInfo, len(f.objects))
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, opts.Prefix) {
+			ch <- minio.ObjectInfo{Key: key, Size: int64(len(data))}
+		}
+	}
+	close(ch)
+	return ch
+}
+
+func (f *fakeS3) openUploads() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.uploads)
+}
+
+func partETag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func newTestS3Service(t *testing.T) (*FileService, *fakeS3) {
+	t.Helper()
+
+	service, _ := newTestFileService(t)
+	client := newFakeS3()
+	service.storages.Register(models.StorageS3, &S3Storage{config: &models.S3Config{Bucket: "test"}, client: client})
+	return service, client
+}
+
+func createTestS3File(t *testing.T, service *FileService, fileName string, content []byte, chunkCount int) *models.File {
+	t.Helper()
+
+	sum := md5.Sum(content)
+	file, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    fileName,
+		FileSize:    int64(len(content)),
+		Hash:        hex.EncodeToString(sum[:]),
+		ChunkCount:  chunkCount,
+		ChunkSize:   s3MinPartSize,
+		StorageType: models.StorageS3,
+	})
+	if err != nil {
+		t.Fatalf("创建文件失败: %v", err)
+	}
+	return file
+}
+
+// 分片映射为part，合并后对象内容完整，multipart upload不再保留
+func TestS3UploadAndMerge(t *testing.T) {
+	service, client := newTestS3Service(t)
+	service.StartMergeWorkers(1)
+
+	content := make([]byte, s3MinPartSize+1000)
+	rand.Read(content)
+	file := createTestS3File(t, service, "data.bin", content, 2)
+	if file.UploadID == "" {
+		t.Fatalf("创建文件时应创建multipart upload")
+	}
+
+	for i := 0; i < 2; i++ {
+		chunk := content[i*s3MinPartSize : min((i+1)*s3MinPartSize, len(content))]
+		if err := service.UploadChunkStream(1, file.ID, i, bytes.NewReader(chunk), models.ChunkChecksum{}); err != nil {
+			t.Fatalf("上传分片 %d 失败: %v", i, err)
+		}
+	}
+
+	stored := waitForMerge(t, service, file.ID)
+	if stored.Status != models.FileStatusCompleted {
+		t.Fatalf("合并失败: %s %s", stored.Status, stored.FailReason)
+	}
+	if !bytes.Equal(client.objects[stored.FilePath], content) {
+		t.Fatalf("合并后的对象内容不一致")
+	}
+	if n := client.openUploads(); n != 0 {
+		t.Fatalf("合并后不应保留multipart upload，实际 %d 个", n)
+	}
+
+	_, reader, err := service.OpenFileForDownload(1, file.ID)
+	if err != nil {
+		t.Fatalf("打开文件失败: %v", err)
+	}
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(data, content) {
+		t.Fatalf("下载的内容不一致")
+	}
+
+	purgeTestFile(t, service, file.ID)
+	if _, ok := client.objects[stored.FilePath]; ok {
+		t.Fatalf("彻底删除后应删除对象")
+	}
+}
+
+// 分片数据超出声明的分片大小时报错
+func TestS3SaveChunkRejectsOversizedChunk(t *testing.T) {
+	client := newFakeS3()
+	storage := &S3Storage{config: &models.S3Config{Bucket: "test"}, client: client}
+	file := &models.File{FilePath: "1/a/b/c/data.bin", FileSize: 10, ChunkSize: 10, ChunkCount: 1}
+	if err := storage.InitUpload(file); err != nil {
+		t.Fatalf("创建multipart upload失败: %v", err)
+	}
+
+	err := storage.SaveChunk(file, 0, strings.NewReader("01234567890"))
+	if err == nil || !strings.Contains(err.Error(), "超出分片大小") {
+		t.Fatalf("超长的分片应报错，实际: %v", err)
+	}
+	if err := storage.SaveChunk(file, 0, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("大小正确的分片上传失败: %v", err)
+	}
+}
+
+// 彻底删除未完成的上传时中止multipart upload
+func TestS3PurgeAbortsUpload(t *testing.T) {
+	service, client := newTestS3Service(t)
+
+	content := make([]byte, s3MinPartSize+1000)
+	file := createTestS3File(t, service, "data.bin", content, 2)
+	if err := service.UploadChunkStream(1, file.ID, 0, bytes.NewReader(content[:s3MinPartSize]), models.ChunkChecksum{}); err != nil {
+		t.Fatalf("上传分片失败: %v", err)
+	}
+
+	purgeTestFile(t, service, file.ID)
+	if n := client.openUploads(); n != 0 {
+		t.Fatalf("彻底删除后应中止multipart upload，实际剩余 %d 个", n)
+	}
+}
+
+// 创建记录失败时中止已创建的multipart upload
+func TestS3CreateFileAbortsUploadWhenInsertFails(t *testing.T) {
+	service, client := newTestS3Service(t)
+
+	err := service.db.Callback().Create().Before("gorm:create").Register("test:fail_file_create", func(db *gorm.DB) {
+		if _, ok := db.Statement.Dest.(*models.File); ok {
+			db.AddError(errors.New("插入失败"))
+		}
+	})
+	if err != nil {
+		t.Fatalf("注册回调失败: %v", err)
+	}
+
+	_, err = service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    "data.bin",
+		FileSize:    100,
+		ChunkCount:  1,
+		ChunkSize:   100,
+		StorageType: models.StorageS3,
+	})
+	if err == nil {
+		t.Fatalf("插入失败时创建文件应报错")
+	}
+	if n := client.openUploads(); n != 0 {
+		t.Fatalf("插入失败时应中止multipart upload，实际剩余 %d 个", n)
+	}
+}
@@ -1,10 +1,13 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"go-auth-server/models"
@@ -15,54 +18,106 @@ import (
 
 type SFTPService struct {
 	config *models.SFTPConfig
+	pool   *SFTPPool
 }
 
 func NewSFTPService(config *models.SFTPConfig) *SFTPService {
-	return &SFTPService{config: config}
+	s := &SFTPService{config: config}
+	s.pool = getSFTPPool(config, func() (*ssh.Client, error) {
+		sshClient, _, err := s.dial()
+		return sshClient, err
+	})
+	return s
 }
 
-// 创建SFTP连接
-func (s *SFTPService) createConnection() (*sftp.Client, error) {
+// 建立SSH连接并返回实际生效的认证方式，由连接池在需要新连接时调用
+func (s *SFTPService) dial() (*ssh.Client, string, error) {
+	hostKeyCallback, err := sftpHostKeyCallback(s.config)
+	if err != nil {
+		return nil, "", err
+	}
+	auth, err := newSFTPAuth(s.config)
+	if err != nil {
+		return nil, "", err
+	}
+	// agent只在握手期间使用
+	defer auth.Close()
+
 	// SSH客户端配置
 	sshConfig := &ssh.ClientConfig{
-		User: s.config.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(s.config.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 生产环境中应该验证主机密钥
+		User:            s.config.Username,
+		Auth:            auth.methods,
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         30 * time.Second,
 	}
 
 	// 连接到SSH服务器
 	sshClient, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", s.config.Host, s.config.Port), sshConfig)
 	if err != nil {
-		return nil, fmt.Errorf("SSH连接失败: %v", err)
+		// 主机密钥错误单独返回，便于与网络或认证失败区分
+		var mismatch *HostKeyMismatchError
+		if errors.As(err, &mismatch) {
+			return nil, "", mismatch
+		}
+		var unknown *UnknownHostKeyError
+		if errors.As(err, &unknown) {
+			return nil, "", unknown
+		}
+		return nil, "", fmt.Errorf("SSH连接失败: %v", err)
 	}
+	return sshClient, auth.used, nil
+}
 
-	// 创建SFTP客户端
-	sftpClient, err := sftp.NewClient(sshClient)
+// 从连接池获取SFTP连接，调用方用完后调用release归还
+func (s *SFTPService) acquire() (*sftp.Client, func(), error) {
+	conn, err := s.pool.Get()
 	if err != nil {
-		sshClient.Close()
-		return nil, fmt.Errorf("SFTP客户端创建失败: %v", err)
+		return nil, nil, err
 	}
+	return conn.client, func() { s.pool.Put(conn) }, nil
+}
+
+// 生成文件存储路径
+func (s *SFTPService) GenerateFilePath(userID uint, fileName string) string {
+	hashStr := pathHash(userID, fileName)
+	return filepath.Join(s.config.BasePath, fmt.Sprintf("%d", userID), hashStr[:2], hashStr[2:4], hashStr[4:], fileName)
+}
+
+// 分片目录和按偏移直接写入的数据文件
+func (s *SFTPService) chunkDir(file *models.File) string {
+	return filepath.Join(s.config.BasePath, "chunks", fmt.Sprintf("%d", file.ID))
+}
 
-	return sftpClient, nil
+func (s *SFTPService) dataPath(file *models.File) string {
+	return filepath.Join(s.chunkDir(file), "data.part")
 }
 
-// 保存分片到SFTP
-func (s *SFTPService) SaveChunk(file models.File, chunkIndex int, data []byte) error {
-	sftpClient, err := s.createConnection()
+// 分片大小固定时才能按序号计算偏移直接写入
+func (s *SFTPService) directWrite(file *models.File) bool {
+	return !s.config.DisableDirectWrite && file.ChunkSize > 0
+}
+
+// 保存分片到SFTP。优先按偏移写入数据文件，无法打开数据文件时回退为单独的分片文件
+func (s *SFTPService) SaveChunk(file *models.File, chunkIndex int, data io.Reader) error {
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// 创建分片目录
-	chunkDir := filepath.Join(s.config.BasePath, "chunks", fmt.Sprintf("%d", file.ID))
+	chunkDir := s.chunkDir(file)
 	if err := s.createRemoteDirectory(sftpClient, chunkDir); err != nil {
 		return fmt.Errorf("创建分片目录失败: %v", err)
 	}
 
+	if s.directWrite(file) {
+		if dataFile, err := s.openDataFile(sftpClient, file); err == nil {
+			defer dataFile.Close()
+			return writeChunkAt(dataFile, file, chunkIndex, data)
+		}
+	}
+
 	// 保存分片文件
 	chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", chunkIndex))
 	remoteFile, err := sftpClient.Create(chunkPath)
@@ -71,7 +126,7 @@ func (s *SFTPService) SaveChunk(file models.File, chunkIndex int, data []byte) e
 	}
 	defer remoteFile.Close()
 
-	_, err = remoteFile.Write(data)
+	_, err = remoteFile.ReadFrom(data)
 	if err != nil {
 		return fmt.Errorf("写入分片数据失败: %v", err)
 	}
@@ -79,13 +134,44 @@ func (s *SFTPService) SaveChunk(file models.File, chunkIndex int, data []byte) e
 	return nil
 }
 
-// 合并SFTP分片
-func (s *SFTPService) MergeChunks(file *models.File) error {
-	sftpClient, err := s.createConnection()
+// 打开数据文件，首次创建时预分配为文件大小。并发的分片各自打开，写入的区间互不重叠
+func (s *SFTPService) openDataFile(sftpClient *sftp.Client, file *models.File) (*sftp.File, error) {
+	dataFile, err := sftpClient.OpenFile(s.dataPath(file), os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	// 文件大小不会超过FileSize，扩展到FileSize不会覆盖已写入的数据。部分服务器不支持时忽略
+	if info, err := dataFile.Stat(); err == nil && info.Size() < file.FileSize {
+		dataFile.Truncate(file.FileSize)
+	}
+	return dataFile, nil
+}
+
+// 将分片写入数据文件中对应的偏移，最多写入分片大小，避免超长的分片覆盖相邻分片
+func writeChunkAt(dataFile *sftp.File, file *models.File, chunkIndex int, data io.Reader) error {
+	size := expectedChunkSize(file, chunkIndex)
+	if _, err := dataFile.Seek(int64(chunkIndex)*file.ChunkSize, io.SeekStart); err != nil {
+		return fmt.Errorf("定位分片偏移失败: %v", err)
+	}
+	if _, err := dataFile.ReadFrom(io.LimitReader(data, size)); err != nil {
+		return fmt.Errorf("写入分片数据失败: %v", err)
+	}
+
+	// 读取一个字节检查是否还有多余的数据，由调用方按读取的字节数报告分片超长
+	if n, _ := data.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("分片 %d 超出分片大小", chunkIndex)
+	}
+	return nil
+}
+
+// 合并SFTP分片。分片已按偏移写入数据文件时，只需补写回退保存的分片，再在服务器上重命名为目标文件
+func (s *SFTPService) MergeChunks(file *models.File, progress func(merged int)) error {
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// 确保目标目录存在
 	targetDir := filepath.Dir(file.FilePath)
@@ -93,6 +179,15 @@ func (s *SFTPService) MergeChunks(file *models.File) error {
 		return fmt.Errorf("创建目标目录失败: %v", err)
 	}
 
+	chunkDir := s.chunkDir(file)
+	if _, err := sftpClient.Stat(s.dataPath(file)); err == nil && s.directWrite(file) {
+		if err := s.mergeInPlace(sftpClient, file, progress); err != nil {
+			return err
+		}
+		s.logChunkCleanup(sftpClient, file, chunkDir)
+		return nil
+	}
+
 	// 创建目标文件
 	remoteFile, err := sftpClient.Create(file.FilePath)
 	if err != nil {
@@ -101,7 +196,6 @@ func (s *SFTPService) MergeChunks(file *models.File) error {
 	defer remoteFile.Close()
 
 	// 按顺序合并分片
-	chunkDir := filepath.Join(s.config.BasePath, "chunks", fmt.Sprintf("%d", file.ID))
 	for i := 0; i < file.ChunkCount; i++ {
 		chunkPath := filepath.Join(chunkDir, fmt.Sprintf("chunk_%d", i))
 		chunkFile, err := sftpClient.Open(chunkPath)
@@ -114,21 +208,104 @@ func (s *SFTPService) MergeChunks(file *models.File) error {
 		if err != nil {
 			return fmt.Errorf("复制分片 %d 失败: %v", i, err)
 		}
+		progress(i + 1)
 	}
 
 	// 清理分片文件
-	s.cleanupChunks(sftpClient, chunkDir)
+	s.logChunkCleanup(sftpClient, file, chunkDir)
+
+	return nil
+}
+
+// 合并已完成，清理分片失败不影响合并结果，只记录日志
+func (s *SFTPService) logChunkCleanup(sftpClient *sftp.Client, file *models.File, chunkDir string) {
+	if err := s.cleanupChunks(sftpClient, chunkDir); err != nil {
+		log.Printf("清理文件 %d 的分片失败: %v", file.ID, err)
+	}
+}
+
+func (s *SFTPService) mergeInPlace(sftpClient *sftp.Client, file *models.File, progress func(merged int)) error {
+	chunkDir := s.chunkDir(file)
+
+	// 回退保存为分片文件的分片需要复制到对应偏移，其余分片已在数据文件中
+	entries, err := sftpClient.ReadDir(chunkDir)
+	if err != nil {
+		return fmt.Errorf("读取分片目录失败: %v", err)
+	}
+	var fallback []int
+	for _, entry := range entries {
+		var i int
+		if n, _ := fmt.Sscanf(entry.Name(), "chunk_%d", &i); n == 1 && i >= 0 && i < file.ChunkCount {
+			fallback = append(fallback, i)
+		}
+	}
+
+	if len(fallback) > 0 {
+		dataFile, err := sftpClient.OpenFile(s.dataPath(file), os.O_WRONLY)
+		if err != nil {
+			return fmt.Errorf("打开数据文件失败: %v", err)
+		}
+		err = s.copyChunksAt(sftpClient, dataFile, file, fallback)
+		// 句柄可能被服务器复用，只关闭一次
+		if closeErr := dataFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("关闭数据文件失败: %v", closeErr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// 与原来创建目标文件的行为一致，覆盖已存在的同名文件
+	sftpClient.Remove(file.FilePath)
+	if _, ok := sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		err = sftpClient.PosixRename(s.dataPath(file), file.FilePath)
+	} else {
+		err = sftpClient.Rename(s.dataPath(file), file.FilePath)
+	}
+	if err != nil {
+		return fmt.Errorf("移动数据文件失败: %v", err)
+	}
 
+	progress(file.ChunkCount)
+	return nil
+}
+
+func (s *SFTPService) copyChunksAt(sftpClient *sftp.Client, dataFile *sftp.File, file *models.File, chunkIndexes []int) error {
+	for _, i := range chunkIndexes {
+		chunkFile, err := sftpClient.Open(filepath.Join(s.chunkDir(file), fmt.Sprintf("chunk_%d", i)))
+		if err != nil {
+			return fmt.Errorf("打开分片 %d 失败: %v", i, err)
+		}
+		err = writeChunkAt(dataFile, file, i, chunkFile)
+		chunkFile.Close()
+		if err != nil {
+			return fmt.Errorf("复制分片 %d 失败: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// 删除分片
+func (s *SFTPService) DeleteChunks(file *models.File) error {
+	sftpClient, release, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.cleanupChunks(sftpClient, s.chunkDir(file)); err != nil {
+		return fmt.Errorf("删除分片失败: %v", err)
+	}
 	return nil
 }
 
 // 下载文件
 func (s *SFTPService) DownloadFile(file models.File, localPath string) error {
-	sftpClient, err := s.createConnection()
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// 打开远程文件
 	remoteFile, err := sftpClient.Open(file.FilePath)
@@ -153,13 +330,42 @@ func (s *SFTPService) DownloadFile(file models.File, localPath string) error {
 	return nil
 }
 
+// 远程文件读取器，关闭时将SFTP连接归还连接池
+type remoteFileReader struct {
+	*sftp.File
+	release func()
+	once    sync.Once
+}
+
+func (r *remoteFileReader) Close() error {
+	err := r.File.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// 打开远程文件用于流式读取，调用方负责关闭
+func (s *SFTPService) OpenFile(file *models.File) (io.ReadSeekCloser, error) {
+	sftpClient, release, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteFile, err := sftpClient.Open(file.FilePath)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("打开远程文件失败: %v", err)
+	}
+
+	return &remoteFileReader{File: remoteFile, release: release}, nil
+}
+
 // 删除文件
-func (s *SFTPService) DeleteFile(file models.File) error {
-	sftpClient, err := s.createConnection()
+func (s *SFTPService) DeleteFile(file *models.File) error {
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// 删除文件
 	err = sftpClient.Remove(file.FilePath)
@@ -193,23 +399,32 @@ func (s *SFTPService) createRemoteDirectory(sftpClient *sftp.Client, dirPath str
 	return sftpClient.Mkdir(dirPath)
 }
 
-// 清理分片文件
-func (s *SFTPService) cleanupChunks(sftpClient *sftp.Client, chunkDir string) {
+// 清理分片目录，包括分片文件和按偏移写入的数据文件。目录不存在时视为已清理
+func (s *SFTPService) cleanupChunks(sftpClient *sftp.Client, chunkDir string) error {
 	// 列出分片目录中的所有文件
 	files, err := sftpClient.ReadDir(chunkDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return
+		return fmt.Errorf("读取分片目录失败: %v", err)
 	}
 
 	// 删除所有分片文件
 	for _, file := range files {
-		if !file.IsDir() {
-			sftpClient.Remove(filepath.Join(chunkDir, file.Name()))
+		if file.IsDir() {
+			continue
+		}
+		if err := sftpClient.Remove(filepath.Join(chunkDir, file.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("删除分片文件 %s 失败: %v", file.Name(), err)
 		}
 	}
 
 	// 删除分片目录
-	sftpClient.Rmdir(chunkDir)
+	if err := sftpClient.RemoveDirectory(chunkDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("删除分片目录失败: %v", err)
+	}
+	return nil
 }
 
 // 清理空目录
@@ -221,7 +436,7 @@ func (s *SFTPService) cleanupEmptyDirectories(sftpClient *sftp.Client, dirPath s
 	}
 
 	// 删除空目录
-	sftpClient.Rmdir(dirPath)
+	sftpClient.RemoveDirectory(dirPath)
 
 	// 递归清理父目录
 	parentDir := filepath.Dir(dirPath)
@@ -230,41 +445,47 @@ func (s *SFTPService) cleanupEmptyDirectories(sftpClient *sftp.Client, dirPath s
 	}
 }
 
-// 检查SFTP连接
-func (s *SFTPService) TestConnection() error {
-	sftpClient, err := s.createConnection()
+// 检查SFTP连接，重新握手以校验当前的主机密钥和凭据，返回生效的认证方式
+func (s *SFTPService) TestConnection() (string, error) {
+	sshClient, authMethod, err := s.dial()
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer sshClient.Close()
+
+	// 关闭SSH连接即可释放SFTP会话
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return "", fmt.Errorf("SFTP客户端创建失败: %v", err)
 	}
-	defer sftpClient.Close()
 
 	// 尝试列出根目录
 	_, err = sftpClient.ReadDir(s.config.BasePath)
 	if err != nil {
-		return fmt.Errorf("无法访问基础路径: %v", err)
+		return "", fmt.Errorf("无法访问基础路径: %v", err)
 	}
 
-	return nil
+	return authMethod, nil
 }
 
 // 获取文件信息
-func (s *SFTPService) GetFileInfo(file models.File) (os.FileInfo, error) {
-	sftpClient, err := s.createConnection()
+func (s *SFTPService) GetFileInfo(file *models.File) (os.FileInfo, error) {
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	return sftpClient.Stat(file.FilePath)
 }
 
 // 列出目录内容
 func (s *SFTPService) ListDirectory(path string) ([]os.FileInfo, error) {
-	sftpClient, err := s.createConnection()
+	sftpClient, release, err := s.acquire()
 	if err != nil {
 		return nil, err
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	return sftpClient.ReadDir(path)
 }
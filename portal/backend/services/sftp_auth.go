@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"go-auth-server/models"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SFTP认证方式
+const (
+	SFTPAuthPassword   = "password"
+	SFTPAuthPrivateKey = "privateKey"
+	SFTPAuthAgent      = "agent"
+)
+
+// 服务器提供的主机密钥与配置的不一致，可能存在中间人攻击
+type HostKeyMismatchError struct {
+	Host        string
+	Fingerprint string
+	Expected    []string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("SFTP主机密钥不匹配：%s 提供的密钥指纹为 %s，期望 %s，可能存在中间人攻击",
+		e.Host, e.Fingerprint, strings.Join(e.Expected, ", "))
+}
+
+// 服务器不在known_hosts中
+type UnknownHostKeyError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("SFTP主机 %s 不在known_hosts中，密钥指纹为 %s，确认后请加入known_hosts或配置主机密钥指纹",
+		e.Host, e.Fingerprint)
+}
+
+// 根据配置构造主机密钥校验
+func sftpHostKeyCallback(config *models.SFTPConfig) (ssh.HostKeyCallback, error) {
+	if len(config.HostKeyFingerprints) > 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			sha256 := ssh.FingerprintSHA256(key)
+			md5 := ssh.FingerprintLegacyMD5(key)
+			for _, fingerprint := range config.HostKeyFingerprints {
+				if fingerprint == sha256 || strings.TrimPrefix(fingerprint, "MD5:") == md5 {
+					return nil
+				}
+			}
+			return &HostKeyMismatchError{Host: hostname, Fingerprint: sha256, Expected: config.HostKeyFingerprints}
+		}, nil
+	}
+
+	if config.KnownHostsPath != "" {
+		callback, err := knownhosts.New(config.KnownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取known_hosts失败: %v", err)
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := callback(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if errors.As(err, &keyErr) {
+				fingerprint := ssh.FingerprintSHA256(key)
+				if len(keyErr.Want) == 0 {
+					return &UnknownHostKeyError{Host: hostname, Fingerprint: fingerprint}
+				}
+				expected := make([]string, 0, len(keyErr.Want))
+				for _, want := range keyErr.Want {
+					expected = append(expected, ssh.FingerprintSHA256(want.Key))
+				}
+				return &HostKeyMismatchError{Host: hostname, Fingerprint: fingerprint, Expected: expected}
+			}
+			return err
+		}, nil
+	}
+
+	if config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return nil, fmt.Errorf("SFTP未配置主机密钥校验")
+}
+
+// 认证过程中记录最后尝试的认证方式，握手成功时即为实际生效的方式
+type sftpAuth struct {
+	methods []ssh.AuthMethod
+	used    string
+	agent   net.Conn
+}
+
+func (a *sftpAuth) Close() {
+	if a.agent != nil {
+		a.agent.Close()
+	}
+}
+
+// 根据配置构造认证方式：私钥和ssh-agent的密钥合并为一个publickey方式，失败后再尝试密码
+func newSFTPAuth(config *models.SFTPConfig) (*sftpAuth, error) {
+	auth := &sftpAuth{}
+
+	var signers []ssh.Signer
+	if config.PrivateKey != "" || config.PrivateKeyPath != "" {
+		signer, err := parsePrivateKey(config)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, auth.recordSigner(signer, SFTPAuthPrivateKey))
+	}
+
+	if config.UseAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("已启用ssh-agent认证，但未设置SSH_AUTH_SOCK")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("连接ssh-agent失败: %v", err)
+		}
+		auth.agent = conn
+
+		agentSigners, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			auth.Close()
+			return nil, fmt.Errorf("读取ssh-agent密钥失败: %v", err)
+		}
+		for _, signer := range agentSigners {
+			signers = append(signers, auth.recordSigner(signer, SFTPAuthAgent))
+		}
+	}
+
+	if len(signers) > 0 {
+		auth.methods = append(auth.methods, ssh.PublicKeys(signers...))
+	}
+	if config.Password != "" {
+		auth.methods = append(auth.methods, ssh.PasswordCallback(func() (string, error) {
+			auth.used = SFTPAuthPassword
+			return config.Password, nil
+		}))
+	}
+
+	if len(auth.methods) == 0 {
+		auth.Close()
+		return nil, fmt.Errorf("没有可用的SFTP认证方式")
+	}
+	return auth, nil
+}
+
+func parsePrivateKey(config *models.SFTPConfig) (ssh.Signer, error) {
+	pemBytes := []byte(config.PrivateKey)
+	if config.PrivateKeyPath != "" {
+		var err error
+		if pemBytes, err = os.ReadFile(config.PrivateKeyPath); err != nil {
+			return nil, fmt.Errorf("读取私钥文件失败: %v", err)
+		}
+	}
+
+	if config.PrivateKeyPassphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(config.PrivateKeyPassphrase))
+		if err != nil {
+			return nil, fmt.Errorf("解析私钥失败，请检查私钥口令: %v", err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("私钥已加密，需要配置私钥口令")
+		}
+		return nil, fmt.Errorf("解析私钥失败: %v", err)
+	}
+	return signer, nil
+}
+
+// 包装签名器，签名时记录认证方式。保留AlgorithmSigner以便RSA密钥使用rsa-sha2算法
+func (a *sftpAuth) recordSigner(signer ssh.Signer, method string) ssh.Signer {
+	record := func() { a.used = method }
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok {
+		return &recordingAlgorithmSigner{AlgorithmSigner: algorithmSigner, record: record}
+	}
+	return &recordingSigner{Signer: signer, record: record}
+}
+
+type recordingSigner struct {
+	ssh.Signer
+	record func()
+}
+
+func (s *recordingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.record()
+	return s.Signer.Sign(rand, data)
+}
+
+type recordingAlgorithmSigner struct {
+	ssh.AlgorithmSigner
+	record func()
+}
+
+func (s *recordingAlgorithmSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.record()
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *recordingAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.record()
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
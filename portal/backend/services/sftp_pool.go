@@ -0,0 +1,411 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-auth-server/models"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// 池中的一条SSH连接及其SFTP会话。*sftp.Client可以并发使用，多个操作可以共享同一条连接
+type sftpConn struct {
+	ssh     *ssh.Client
+	client  *sftp.Client
+	dropped atomic.Bool // 服务端断开或网络中断
+
+	// 以下字段由池的mu保护
+	active   int // 正在使用该连接的操作数
+	lastUsed time.Time
+	lastOK   time.Time // 最近一次确认连接可用的时间
+}
+
+// 先断开SSH连接，避免服务端不响应时关闭SFTP会话被阻塞
+func (c *sftpConn) close() {
+	c.ssh.Close()
+	c.client.Close()
+}
+
+// SFTP连接池，按配置复用SSH连接，避免每次操作都重新握手。
+// MaxConns限制的是连接数而不是并发操作数：没有空闲连接且连接数已满时，
+// 新的操作共享负载最少的连接，长时间的下载不会耗尽连接池
+type SFTPPool struct {
+	target string
+	config *models.SFTPConfig
+	dial   func() (*ssh.Client, error)
+
+	mu     sync.Mutex
+	conns  []*sftpConn
+	slots  chan struct{} // 限制同时打开的连接数，包括正在建立的连接
+	closed chan struct{}
+
+	waiting             atomic.Int64
+	dials               atomic.Int64
+	dialErrors          atomic.Int64
+	reuses              atomic.Int64
+	healthCheckFailures atomic.Int64
+	dropped             atomic.Int64
+	idleClosed          atomic.Int64
+	waitTimeouts        atomic.Int64
+}
+
+var (
+	sftpPoolsMu sync.Mutex
+	sftpPools   = map[string]*SFTPPool{}
+)
+
+// 获取配置对应的连接池，相同配置的服务共享同一个池
+func getSFTPPool(config *models.SFTPConfig, dial func() (*ssh.Client, error)) *SFTPPool {
+	key := sftpPoolKey(config)
+
+	sftpPoolsMu.Lock()
+	defer sftpPoolsMu.Unlock()
+	if pool, ok := sftpPools[key]; ok {
+		return pool
+	}
+	pool := newSFTPPool(config, dial)
+	sftpPools[key] = pool
+	return pool
+}
+
+// 池的键包含认证和主机密钥配置的摘要，修改凭据后不会复用旧连接
+func sftpPoolKey(config *models.SFTPConfig) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q|%q|%q|%q|%t|%q|%q|%t", config.Password, config.PrivateKey,
+		config.PrivateKeyPath, config.PrivateKeyPassphrase, config.UseAgent, config.KnownHostsPath,
+		config.HostKeyFingerprints, config.InsecureIgnoreHostKey)))
+	return fmt.Sprintf("%s@%s:%d/%s#%s", config.Username, config.Host, config.Port,
+		config.BasePath, hex.EncodeToString(sum[:8]))
+}
+
+// 关闭并移除配置对应的连接池，用于目标被修改或删除后
+func closeSFTPPool(config *models.SFTPConfig) {
+	key := sftpPoolKey(config)
+
+	sftpPoolsMu.Lock()
+	pool, ok := sftpPools[key]
+	delete(sftpPools, key)
+	sftpPoolsMu.Unlock()
+
+	if ok {
+		pool.Close()
+	}
+}
+
+func newSFTPPool(config *models.SFTPConfig, dial func() (*ssh.Client, error)) *SFTPPool {
+	maxConns := config.MaxConns
+	if maxConns <= 0 {
+		maxConns = 1
+	}
+
+	pool := &SFTPPool{
+		target: fmt.Sprintf("%s@%s:%d", config.Username, config.Host, config.Port),
+		config: config,
+		dial:   dial,
+		slots:  make(chan struct{}, maxConns),
+		closed: make(chan struct{}),
+	}
+	if config.IdleTimeout > 0 {
+		go pool.reapIdle()
+	}
+	return pool
+}
+
+// 获取一条可用连接，用完后必须调用Put归还。优先使用空闲连接，其次建立新连接，
+// 连接数已满时与其他操作共享连接。只有所有连接都在建立中时才需要等待
+func (p *SFTPPool) Get() (*sftpConn, error) {
+	for {
+		conn := p.takeIdle()
+		if conn == nil {
+			break
+		}
+		if p.healthy(conn) {
+			p.reuses.Add(1)
+			return conn, nil
+		}
+		// 最后一个使用者归还时关闭
+		conn.dropped.Store(true)
+		p.Put(conn)
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return p.connect()
+	default:
+	}
+
+	if conn := p.share(); conn != nil {
+		p.reuses.Add(1)
+		return conn, nil
+	}
+
+	if err := p.acquireSlot(); err != nil {
+		return nil, err
+	}
+	return p.connect()
+}
+
+// 归还连接，已断开的连接在没有其他使用者时关闭，下次Get时自动重连
+func (p *SFTPPool) Put(conn *sftpConn) {
+	p.mu.Lock()
+	conn.active--
+	now := time.Now()
+	conn.lastUsed = now
+	conn.lastOK = now
+
+	closing := false
+	if conn.active == 0 {
+		select {
+		case <-p.closed:
+			// 连接池已关闭，不再保留连接
+			closing = true
+		default:
+			closing = conn.dropped.Load()
+		}
+	}
+	if closing {
+		p.removeLocked(conn)
+	}
+	p.mu.Unlock()
+
+	if closing {
+		p.discard(conn)
+	}
+}
+
+// 关闭连接池及其中的空闲连接，使用中的连接在最后一个使用者归还时关闭
+func (p *SFTPPool) Close() {
+	p.mu.Lock()
+	close(p.closed)
+	idle := p.removeIdleLocked(func(*sftpConn) bool { return true })
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		p.discard(conn)
+	}
+}
+
+func (p *SFTPPool) Stats() models.SFTPPoolStats {
+	p.mu.Lock()
+	open, idle, operations := len(p.conns), 0, 0
+	for _, conn := range p.conns {
+		if conn.active == 0 {
+			idle++
+		}
+		operations += conn.active
+	}
+	p.mu.Unlock()
+
+	return models.SFTPPoolStats{
+		Target:              p.target,
+		MaxConns:            cap(p.slots),
+		Open:                open,
+		Idle:                idle,
+		InUse:               open - idle,
+		Operations:          operations,
+		Waiting:             p.waiting.Load(),
+		Dials:               p.dials.Load(),
+		DialErrors:          p.dialErrors.Load(),
+		Reuses:              p.reuses.Load(),
+		HealthCheckFailures: p.healthCheckFailures.Load(),
+		Dropped:             p.dropped.Load(),
+		IdleClosed:          p.idleClosed.Load(),
+		WaitTimeouts:        p.waitTimeouts.Load(),
+	}
+}
+
+// 等待连接名额，超过WaitTimeout仍没有名额时返回错误
+func (p *SFTPPool) acquireSlot() error {
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+
+	var timeout <-chan time.Time
+	if p.config.WaitTimeout > 0 {
+		timer := time.NewTimer(p.config.WaitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		p.waitTimeouts.Add(1)
+		return fmt.Errorf("等待SFTP连接超时，连接池已满（%d）", cap(p.slots))
+	}
+}
+
+// 取出一条空闲连接。后进先出，优先复用最近使用的连接，让多余的连接自然空闲超时
+func (p *SFTPPool) takeIdle() *sftpConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.conns) - 1; i >= 0; i-- {
+		conn := p.conns[i]
+		if conn.active == 0 {
+			conn.active++
+			return conn
+		}
+	}
+	return nil
+}
+
+// 选择使用者最少的连接与其他操作共享
+func (p *SFTPPool) share() *sftpConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var least *sftpConn
+	for _, conn := range p.conns {
+		if conn.dropped.Load() {
+			continue
+		}
+		if least == nil || conn.active < least.active {
+			least = conn
+		}
+	}
+	if least != nil {
+		least.active++
+	}
+	return least
+}
+
+// 检查空闲连接是否仍可用，刚使用过的连接跳过检查
+func (p *SFTPPool) healthy(conn *sftpConn) bool {
+	if conn.dropped.Load() {
+		return false
+	}
+
+	p.mu.Lock()
+	lastUsed, lastOK := conn.lastUsed, conn.lastOK
+	p.mu.Unlock()
+	if p.config.IdleTimeout > 0 && time.Since(lastUsed) > p.config.IdleTimeout {
+		p.idleClosed.Add(1)
+		return false
+	}
+	if time.Since(lastOK) < p.config.HealthCheckInterval {
+		return true
+	}
+
+	if _, err := conn.client.Getwd(); err != nil {
+		p.healthCheckFailures.Add(1)
+		return false
+	}
+	p.mu.Lock()
+	conn.lastOK = time.Now()
+	p.mu.Unlock()
+	return true
+}
+
+// 使用已获取的名额建立新连接，失败时归还名额
+func (p *SFTPPool) connect() (*sftpConn, error) {
+	p.dials.Add(1)
+	sshClient, err := p.dial()
+	if err != nil {
+		<-p.slots
+		p.dialErrors.Add(1)
+		return nil, err
+	}
+
+	// 创建SFTP客户端
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		<-p.slots
+		p.dialErrors.Add(1)
+		return nil, fmt.Errorf("SFTP客户端创建失败: %v", err)
+	}
+
+	now := time.Now()
+	conn := &sftpConn{ssh: sshClient, client: sftpClient, active: 1, lastUsed: now, lastOK: now}
+	p.mu.Lock()
+	p.conns = append(p.conns, conn)
+	p.mu.Unlock()
+
+	// 服务端断开时标记连接，不再分配给新的操作，最后一个使用者归还时关闭
+	go func() {
+		sshClient.Wait()
+		if !conn.dropped.Swap(true) {
+			p.dropped.Add(1)
+		}
+	}()
+	return conn, nil
+}
+
+// 关闭已从池中移除的连接并归还名额
+func (p *SFTPPool) discard(conn *sftpConn) {
+	// 主动关闭的连接不计入服务端断开
+	conn.dropped.Store(true)
+	conn.close()
+	<-p.slots
+}
+
+func (p *SFTPPool) removeLocked(conn *sftpConn) {
+	for i, c := range p.conns {
+		if c == conn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// 移除满足条件的空闲连接，由调用方在释放锁后关闭
+func (p *SFTPPool) removeIdleLocked(match func(*sftpConn) bool) []*sftpConn {
+	var removed []*sftpConn
+	kept := p.conns[:0]
+	for _, conn := range p.conns {
+		if conn.active == 0 && match(conn) {
+			removed = append(removed, conn)
+		} else {
+			kept = append(kept, conn)
+		}
+	}
+	p.conns = kept
+	return removed
+}
+
+// 定期关闭空闲超时和已断开的空闲连接
+func (p *SFTPPool) reapIdle() {
+	ticker := time.NewTicker(p.config.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		expired := p.removeIdleLocked(func(conn *sftpConn) bool {
+			return time.Since(conn.lastUsed) > p.config.IdleTimeout || conn.dropped.Load()
+		})
+		p.mu.Unlock()
+
+		for _, conn := range expired {
+			if !conn.dropped.Load() {
+				p.idleClosed.Add(1)
+			}
+			p.discard(conn)
+		}
+	}
+}
+
+// 所有SFTP连接池的统计
+func SFTPPoolStats() []models.SFTPPoolStats {
+	sftpPoolsMu.Lock()
+	defer sftpPoolsMu.Unlock()
+
+	stats := make([]models.SFTPPoolStats, 0, len(sftpPools))
+	for _, pool := range sftpPools {
+		stats = append(stats, pool.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Target < stats[j].Target })
+	return stats
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"go-auth-server/config"
+	"go-auth-server/models"
+	"go-auth-server/utils"
+
+	"gorm.io/gorm"
+)
+
+// 获取SFTP目标列表
+func (s *FileService) ListSFTPTargets() ([]models.SFTPTarget, error) {
+	var targets []models.SFTPTarget
+	if err := s.db.Order("name").Find(&targets).Error; err != nil {
+		return nil, fmt.Errorf("获取SFTP目标失败: %v", err)
+	}
+	for i := range targets {
+		maskSFTPTarget(&targets[i])
+	}
+	return targets, nil
+}
+
+// 创建SFTP目标
+func (s *FileService) CreateSFTPTarget(req *models.SFTPTargetRequest) (*models.SFTPTarget, error) {
+	target := &models.SFTPTarget{}
+	if err := s.applySFTPTargetRequest(target, req); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&models.SFTPTarget{}).Where("name = ?", target.Name).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("SFTP目标名称已存在")
+		}
+		if err := clearDefaultSFTPTarget(tx, target); err != nil {
+			return err
+		}
+		if err := tx.Create(target).Error; err != nil {
+			return fmt.Errorf("创建SFTP目标失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	maskSFTPTarget(target)
+	return target, nil
+}
+
+// 更新SFTP目标。已有文件按目标ID关联，修改名称不影响这些文件；
+// 修改主机或基础路径时需要自行迁移服务器上的数据
+func (s *FileService) UpdateSFTPTarget(id uint, req *models.SFTPTargetRequest) (*models.SFTPTarget, error) {
+	var target models.SFTPTarget
+	if err := s.db.First(&target, id).Error; err != nil {
+		return nil, fmt.Errorf("SFTP目标不存在")
+	}
+	oldConfig, _ := s.sftpTargetConfig(&target)
+
+	if err := s.applySFTPTargetRequest(&target, req); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&models.SFTPTarget{}).Where("name = ? AND id <> ?", target.Name, target.ID).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("SFTP目标名称已存在")
+		}
+		if err := clearDefaultSFTPTarget(tx, &target); err != nil {
+			return err
+		}
+		if err := tx.Save(&target).Error; err != nil {
+			return fmt.Errorf("更新SFTP目标失败: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 连接配置变化时旧连接不再复用，只修改名称或默认标记时保留连接池
+	if newConfig, err := s.sftpTargetConfig(&target); oldConfig != nil && err == nil &&
+		sftpPoolKey(oldConfig) != sftpPoolKey(newConfig) {
+		closeSFTPPool(oldConfig)
+	}
+
+	maskSFTPTarget(&target)
+	return &target, nil
+}
+
+// 删除SFTP目标，仍有文件（包括回收站中的文件）存储在该目标上时不允许删除
+func (s *FileService) DeleteSFTPTarget(id uint) error {
+	var target models.SFTPTarget
+	if err := s.db.First(&target, id).Error; err != nil {
+		return fmt.Errorf("SFTP目标不存在")
+	}
+
+	var files int64
+	s.db.Model(&models.File{}).Where("sftp_target_id = ?", target.ID).Count(&files)
+	if files > 0 {
+		return fmt.Errorf("仍有 %d 个文件存储在该目标上，无法删除", files)
+	}
+
+	if err := s.db.Delete(&target).Error; err != nil {
+		return fmt.Errorf("删除SFTP目标失败: %v", err)
+	}
+	if cfg, err := s.sftpTargetConfig(&target); err == nil {
+		closeSFTPPool(cfg)
+	}
+	return nil
+}
+
+// 测试SFTP连接，依次按目标名称、临时配置、默认目标测试，返回生效的认证方式
+func (s *FileService) TestSFTPConnection(req *models.SFTPTestRequest) (string, error) {
+	var cfg *models.SFTPConfig
+	switch {
+	case req.Target != "":
+		var target models.SFTPTarget
+		if err := s.db.Where("name = ?", req.Target).First(&target).Error; err != nil {
+			return "", fmt.Errorf("SFTP目标 %s 不存在", req.Target)
+		}
+		var err error
+		if cfg, err = s.sftpTargetConfig(&target); err != nil {
+			return "", err
+		}
+	case req.Config != nil:
+		cfg = s.withSFTPPoolDefaults(req.Config)
+	default:
+		var err error
+		if cfg, err = s.getSFTPConfig(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := config.ValidateSFTPConfig(cfg); err != nil {
+		return "", err
+	}
+	// 测试时直接握手，不创建连接池
+	return (&SFTPService{config: cfg}).TestConnection()
+}
+
+// 默认SFTP目标的配置：数据库中标记为默认的目标优先，否则使用环境变量配置
+func (s *FileService) getSFTPConfig() (*models.SFTPConfig, error) {
+	var target models.SFTPTarget
+	if err := s.db.Where("is_default = ?", true).First(&target).Error; err == nil {
+		return s.sftpTargetConfig(&target)
+	}
+
+	if err := config.ValidateSFTPConfig(s.config.SFTP); err != nil {
+		return nil, err
+	}
+	return s.config.SFTP, nil
+}
+
+// 解析上传时选择的SFTP目标，未指定名称时使用默认目标，没有默认目标时返回nil表示环境变量配置的目标
+func (s *FileService) resolveSFTPTarget(storageType models.StorageType, name string) (*uint, error) {
+	if storageType != models.StorageSFTP {
+		if name != "" {
+			return nil, fmt.Errorf("只有SFTP存储可以指定目标")
+		}
+		return nil, nil
+	}
+
+	var target models.SFTPTarget
+	query := s.db.Where("is_default = ?", true)
+	if name != "" {
+		query = s.db.Where("name = ?", name)
+	}
+	if err := query.First(&target).Error; err != nil {
+		if name != "" {
+			return nil, fmt.Errorf("SFTP目标 %s 不存在", name)
+		}
+		return nil, nil
+	}
+	return &target.ID, nil
+}
+
+// 获取文件所在的存储后端，记录了SFTP目标的文件使用该目标的配置
+func (s *FileService) storageFor(file *models.File) (Storage, error) {
+	return s.storageForTarget(file.StorageType, file.SFTPTargetID)
+}
+
+func (s *FileService) storageForTarget(storageType models.StorageType, targetID *uint) (Storage, error) {
+	if storageType != models.StorageSFTP || targetID == nil {
+		return s.storages.Get(storageType)
+	}
+
+	var target models.SFTPTarget
+	if err := s.db.First(&target, *targetID).Error; err != nil {
+		return nil, fmt.Errorf("SFTP目标 %d 不存在", *targetID)
+	}
+	cfg, err := s.sftpTargetConfig(&target)
+	if err != nil {
+		return nil, err
+	}
+	// 相同配置共享连接池，每次构造服务不会重新握手
+	return NewSFTPService(cfg), nil
+}
+
+// 可选的SFTP目标名称
+func (s *FileService) sftpTargetNames() []string {
+	names := []string{}
+	s.db.Model(&models.SFTPTarget{}).Order("name").Pluck("name", &names)
+	return names
+}
+
+// 解密凭据并补全连接池参数
+func (s *FileService) sftpTargetConfig(target *models.SFTPTarget) (*models.SFTPConfig, error) {
+	password, err := utils.DecryptSecret(s.config.CredentialKey, target.PasswordEnc)
+	if err != nil {
+		return nil, fmt.Errorf("解密SFTP目标 %s 的密码失败: %v", target.Name, err)
+	}
+	privateKey, err := utils.DecryptSecret(s.config.CredentialKey, target.PrivateKeyEnc)
+	if err != nil {
+		return nil, fmt.Errorf("解密SFTP目标 %s 的私钥失败: %v", target.Name, err)
+	}
+	passphrase, err := utils.DecryptSecret(s.config.CredentialKey, target.PassphraseEnc)
+	if err != nil {
+		return nil, fmt.Errorf("解密SFTP目标 %s 的私钥口令失败: %v", target.Name, err)
+	}
+
+	return s.withSFTPPoolDefaults(&models.SFTPConfig{
+		Host:                  target.Host,
+		Port:                  target.Port,
+		Username:              target.Username,
+		Password:              password,
+		BasePath:              target.BasePath,
+		PrivateKey:            privateKey,
+		PrivateKeyPath:        target.PrivateKeyPath,
+		PrivateKeyPassphrase:  passphrase,
+		UseAgent:              target.UseAgent,
+		KnownHostsPath:        target.KnownHostsPath,
+		HostKeyFingerprints:   target.HostKeyFingerprints,
+		InsecureIgnoreHostKey: target.InsecureIgnoreHostKey,
+		DisableDirectWrite:    target.DisableDirectWrite,
+	}), nil
+}
+
+// 未设置连接池参数时使用环境变量中的配置
+func (s *FileService) withSFTPPoolDefaults(cfg *models.SFTPConfig) *models.SFTPConfig {
+	merged := *cfg
+	if merged.MaxConns <= 0 {
+		merged.MaxConns = s.config.SFTP.MaxConns
+	}
+	if merged.IdleTimeout <= 0 {
+		merged.IdleTimeout = s.config.SFTP.IdleTimeout
+	}
+	if merged.HealthCheckInterval <= 0 {
+		merged.HealthCheckInterval = s.config.SFTP.HealthCheckInterval
+	}
+	if merged.WaitTimeout <= 0 {
+		merged.WaitTimeout = s.config.SFTP.WaitTimeout
+	}
+	return &merged
+}
+
+// 将请求写入目标，加密凭据并校验最终配置。未配置CREDENTIAL_KEY时不允许保存目标
+func (s *FileService) applySFTPTargetRequest(target *models.SFTPTarget, req *models.SFTPTargetRequest) error {
+	if s.config.CredentialKey == "" {
+		return fmt.Errorf("未设置CREDENTIAL_KEY环境变量，无法保存SFTP目标")
+	}
+
+	target.Name = strings.TrimSpace(req.Name)
+	target.Host = req.Host
+	target.Port = req.Port
+	target.Username = req.Username
+	target.BasePath = req.BasePath
+	target.PrivateKeyPath = req.PrivateKeyPath
+	target.UseAgent = req.UseAgent
+	target.KnownHostsPath = req.KnownHostsPath
+	target.HostKeyFingerprints = req.HostKeyFingerprints
+	target.InsecureIgnoreHostKey = req.InsecureIgnoreHostKey
+	target.DisableDirectWrite = req.DisableDirectWrite
+	target.IsDefault = req.IsDefault
+	if target.Name == "" {
+		return fmt.Errorf("SFTP目标名称不能为空")
+	}
+
+	secrets := []struct {
+		value *string
+		field *string
+	}{
+		{req.Password, &target.PasswordEnc},
+		{req.PrivateKey, &target.PrivateKeyEnc},
+		{req.PrivateKeyPassphrase, &target.PassphraseEnc},
+	}
+	for _, secret := range secrets {
+		if secret.value == nil {
+			continue
+		}
+		encrypted, err := utils.EncryptSecret(s.config.CredentialKey, *secret.value)
+		if err != nil {
+			return fmt.Errorf("加密凭据失败: %v", err)
+		}
+		*secret.field = encrypted
+	}
+
+	cfg, err := s.sftpTargetConfig(target)
+	if err != nil {
+		return err
+	}
+	return config.ValidateSFTPConfig(cfg)
+}
+
+// 新的默认目标生效前取消其他目标的默认标记
+func clearDefaultSFTPTarget(tx *gorm.DB, target *models.SFTPTarget) error {
+	if !target.IsDefault {
+		return nil
+	}
+	err := tx.Model(&models.SFTPTarget{}).Where("is_default = ? AND id <> ?", true, target.ID).
+		Update("is_default", false).Error
+	if err != nil {
+		return fmt.Errorf("更新默认SFTP目标失败: %v", err)
+	}
+	return nil
+}
+
+// 不返回凭据，只标记是否已配置
+func maskSFTPTarget(target *models.SFTPTarget) {
+	target.HasPassword = target.PasswordEnc != ""
+	target.HasPrivateKey = target.PrivateKeyEnc != ""
+	if target.HostKeyFingerprints == nil {
+		target.HostKeyFingerprints = []string{}
+	}
+}
+
+// 按SFTP目标筛选，targetID为nil时匹配默认目标
+func whereSFTPTarget(query *gorm.DB, targetID *uint) *gorm.DB {
+	if targetID == nil {
+		return query.Where("sftp_target_id IS NULL")
+	}
+	return query.Where("sftp_target_id = ?", *targetID)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"go-auth-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 获取回收站中的文件，按删除时间倒序
+func (s *FileService) GetTrashList(userID uint, req *models.TrashListRequest) (*models.FileListResponse, error) {
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.PageSize < 1 {
+		req.PageSize = 20
+	}
+
+	var files []models.File
+	var total int64
+
+	// 随文件夹一起删除的内容不单独列出
+	query := s.db.Model(&models.File{}).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Where("NOT EXISTS (SELECT 1 FROM files p WHERE p.id = files.parent_id AND p.deleted_at = files.deleted_at)")
+	if err := query.Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("获取文件总数失败: %v", err)
+	}
+
+	offset := (req.Page - 1) * req.PageSize
+	if err := query.Order("deleted_at DESC").Offset(offset).Limit(req.PageSize).Find(&files).Error; err != nil {
+		return nil, fmt.Errorf("获取回收站列表失败: %v", err)
+	}
+
+	totalPages := int((total + int64(req.PageSize) - 1) / int64(req.PageSize))
+
+	return &models.FileListResponse{
+		Files:      files,
+		Total:      total,
+		Page:       req.Page,
+		PageSize:   req.PageSize,
+		TotalPages: totalPages,
+	}, nil
+}
+
+// 从回收站恢复文件，原父文件夹已不存在或已删除时恢复到根目录
+func (s *FileService) RestoreFile(userID uint, fileID uint) (*models.File, error) {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", fileID, userID).First(&file).Error; err != nil {
+		return nil, fmt.Errorf("回收站中不存在该文件")
+	}
+
+	if file.ParentID != nil {
+		var parent models.File
+		err := s.db.Where("id = ? AND user_id = ? AND is_folder = ? AND deleted_at IS NULL", *file.ParentID, userID, true).First(&parent).Error
+		if err != nil {
+			file.ParentID = nil
+		}
+	}
+
+	var existingFile models.File
+	query := whereParent(s.db.Where("user_id = ? AND file_name = ? AND id != ? AND deleted_at IS NULL",
+		userID, file.FileName, file.ID), file.ParentID)
+	if err := query.First(&existingFile).Error; err == nil {
+		return nil, fmt.Errorf("目标位置已存在同名文件，请先重命名或移动")
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// 恢复随文件夹一起删除的内容，之前单独删除的内容仍留在回收站
+		if file.IsFolder {
+			nodes, err := s.loadSubtree(tx, userID, file.ID)
+			if err != nil {
+				return err
+			}
+			var ids []uint
+			for _, node := range nodes[1:] {
+				if node.DeletedAt != nil && file.DeletedAt != nil && node.DeletedAt.Equal(*file.DeletedAt) {
+					ids = append(ids, node.ID)
+				}
+			}
+			if len(ids) > 0 {
+				if err := tx.Model(&models.File{}).Where("id IN ?", ids).Update("deleted_at", nil).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Model(&file).Updates(map[string]interface{}{
+			"parent_id":  file.ParentID,
+			"deleted_at": nil,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("恢复文件失败: %v", err)
+	}
+
+	file.DeletedAt = nil
+	return &file, nil
+}
+
+// 彻底删除回收站中的文件，没有其他记录引用时删除存储中的数据
+func (s *FileService) PurgeFile(userID uint, fileID uint) error {
+	var file models.File
+	if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", fileID, userID).First(&file).Error; err != nil {
+		return fmt.Errorf("回收站中不存在该文件")
+	}
+
+	return s.purgeTree(&file)
+}
+
+// 彻底删除文件，文件夹先删除其中已删除的内容
+func (s *FileService) purgeTree(file *models.File) error {
+	if file.IsFolder {
+		nodes, err := s.loadSubtree(s.db, file.UserID, file.ID)
+		if err != nil {
+			return err
+		}
+		for i := len(nodes) - 1; i > 0; i-- {
+			if nodes[i].DeletedAt == nil {
+				continue
+			}
+			if err := s.purgeFile(&nodes[i].File); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.purgeFile(file)
+}
+
+func (s *FileService) purgeFile(file *models.File) error {
+	if file.Status == models.FileStatusMerging {
+		return fmt.Errorf("文件正在合并，请稍后再删除")
+	}
+
+	// 未完成的上传还有分片数据
+	if file.Status != models.FileStatusCompleted && !file.IsFolder {
+		if _, err := s.cleanupChunks(file); err != nil {
+			return fmt.Errorf("清理分片失败: %v", err)
+		}
+	}
+
+	// 记录删除后再检查引用，秒传和复制的文件与其他记录共享存储数据。
+	// 删除存储数据失败时记录也不删除，可以重新彻底删除
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(file).Error; err != nil {
+			return fmt.Errorf("删除文件记录失败: %v", err)
+		}
+		if file.Status != models.FileStatusCompleted {
+			return nil
+		}
+		if err := s.releaseStoredFile(tx, file); err != nil {
+			return fmt.Errorf("删除存储文件失败: %v", err)
+		}
+		return nil
+	})
+}
+
+// 彻底删除超过保留时间的回收站文件
+func (s *FileService) PurgeExpiredTrash() (int, []string) {
+	if s.config == nil || s.config.TrashRetention <= 0 {
+		return 0, nil
+	}
+
+	var fileIDs []uint
+	s.db.Model(&models.File{}).Where("deleted_at IS NOT NULL AND deleted_at < ?", time.Now().Add(-s.config.TrashRetention)).
+		Order("id").Pluck("id", &fileIDs)
+
+	purged := 0
+	var errs []string
+	for _, fileID := range fileIDs {
+		// 可能已随所在文件夹一起删除
+		var file models.File
+		if err := s.db.Where("id = ? AND deleted_at IS NOT NULL", fileID).First(&file).Error; err != nil {
+			continue
+		}
+		if err := s.purgeTree(&file); err != nil {
+			errs = append(errs, fmt.Sprintf("文件 %d: %v", file.ID, err))
+			continue
+		}
+		purged++
+	}
+	return purged, errs
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"go-auth-server/models"
+)
+
+func uploadTestFile(t *testing.T, service *FileService, fileName string, content []byte) models.File {
+	t.Helper()
+
+	sum := md5.Sum(content)
+	file, err := service.CreateFile(1, &models.FileUploadRequest{
+		FileName:    fileName,
+		FileSize:    int64(len(content)),
+		Hash:        hex.EncodeToString(sum[:]),
+		ChunkCount:  1,
+		ChunkSize:   int64(len(content)),
+		StorageType: storageMemory,
+	})
+	if err != nil {
+		t.Fatalf("创建文件 %s 失败: %v", fileName, err)
+	}
+	// 秒传的文件直接完成
+	if file.Status == models.FileStatusCompleted {
+		return *file
+	}
+
+	if err := service.UploadChunkStream(1, file.ID, 0, bytes.NewReader(content), models.ChunkChecksum{}); err != nil {
+		t.Fatalf("上传文件 %s 失败: %v", fileName, err)
+	}
+	stored := waitForMerge(t, service, file.ID)
+	if stored.Status != models.FileStatusCompleted {
+		t.Fatalf("文件 %s 上传未完成: %s", fileName, stored.Status)
+	}
+	return stored
+}
+
+func purgeTestFile(t *testing.T, service *FileService, fileID uint) {
+	t.Helper()
+
+	if err := service.DeleteFile(1, fileID); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	if err := service.PurgeFile(1, fileID); err != nil {
+		t.Fatalf("彻底删除文件失败: %v", err)
+	}
+}
+
+// 秒传和复制的记录共享存储数据，彻底删除其中一条不影响其他记录，删除最后一条时才删除数据
+func TestPurgeSharedStoredFile(t *testing.T) {
+	service, storage := newTestFileService(t)
+	service.StartMergeWorkers(1)
+
+	content := []byte("shared content for instant upload")
+	original := uploadTestFile(t, service, "a.txt", content)
+	instant := uploadTestFile(t, service, "b.txt", content)
+	if instant.ID == original.ID || instant.FilePath != original.FilePath {
+		t.Fatalf("第二次上传应秒传并共享存储数据")
+	}
+	copied, err := service.CopyFile(1, &models.CopyFileRequest{FileID: original.ID})
+	if err != nil {
+		t.Fatalf("复制文件失败: %v", err)
+	}
+	if copied.FilePath != original.FilePath {
+		t.Fatalf("复制的文件应共享存储数据")
+	}
+
+	stored := func() bool {
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		_, ok := storage.files[original.FilePath]
+		return ok
+	}
+
+	for _, fileID := range []uint{original.ID, instant.ID} {
+		purgeTestFile(t, service, fileID)
+		if !stored() {
+			t.Fatalf("仍有记录引用时不应删除存储数据")
+		}
+	}
+
+	_, reader, err := service.OpenFileForDownload(1, copied.ID)
+	if err != nil {
+		t.Fatalf("剩余的记录应仍可下载: %v", err)
+	}
+	reader.Close()
+
+	purgeTestFile(t, service, copied.ID)
+	if stored() {
+		t.Fatalf("最后一条记录彻底删除后应删除存储数据")
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-auth-server/models"
+
+	"gorm.io/gorm"
+)
+
+// 子树中的节点，Depth为相对根节点的层级
+type treeNode struct {
+	models.File
+	Depth int
+}
+
+// 获取以rootID为根的整个子树（包括根节点），父节点排在子节点之前
+func (s *FileService) loadSubtree(db *gorm.DB, userID uint, rootID uint) ([]treeNode, error) {
+	var nodes []treeNode
+	// depth限制用于防止异常数据形成环时无限递归
+	err := db.Raw(`
+		WITH RECURSIVE subtree(id, depth) AS (
+			SELECT id, 0 FROM files WHERE id = ? AND user_id = ?
+			UNION ALL
+			SELECT f.id, t.depth + 1
+			FROM files f JOIN subtree t ON f.parent_id = t.id
+			WHERE f.user_id = ? AND t.depth < 100
+		)
+		SELECT files.*, subtree.depth FROM files JOIN subtree ON files.id = subtree.id
+		ORDER BY subtree.depth, files.id`,
+		rootID, userID, userID).Scan(&nodes).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取文件夹内容失败: %v", err)
+	}
+	return nodes, nil
+}
+
+// 校验目标父目录是存在且属于该用户的文件夹，nil表示根目录
+func (s *FileService) checkParentFolder(db *gorm.DB, userID uint, parentID *uint) error {
+	if parentID == nil {
+		return nil
+	}
+
+	var parent models.File
+	if err := db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", *parentID, userID).First(&parent).Error; err != nil {
+		return fmt.Errorf("目标文件夹不存在")
+	}
+	if !parent.IsFolder {
+		return fmt.Errorf("目标不是文件夹")
+	}
+	return nil
+}
+
+// 检查目标父目录是否位于文件夹自身的子树中
+func (s *FileService) checkNotDescendant(db *gorm.DB, userID uint, folder *models.File, parentID *uint) error {
+	if !folder.IsFolder || parentID == nil {
+		return nil
+	}
+
+	nodes, err := s.loadSubtree(db, userID, folder.ID)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if node.ID == *parentID {
+			return fmt.Errorf("不能移动或复制到自身或其子文件夹中")
+		}
+	}
+	return nil
+}
+
+// 在目标目录中生成不重名的文件名，如"a.txt"已存在时返回"a (1).txt"
+func (s *FileService) uniqueFileName(db *gorm.DB, userID uint, parentID *uint, fileName string) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+
+	name := fileName
+	for i := 1; ; i++ {
+		var count int64
+		whereParent(db.Model(&models.File{}).Where("user_id = ? AND file_name = ? AND deleted_at IS NULL",
+			userID, name), parentID).Count(&count)
+		if count == 0 {
+			return name
+		}
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// 复制文件或整个文件夹到目标目录。复制的文件与原文件共享存储数据，
+// 存储数据在最后一个引用被彻底删除时才会删除。文件夹中未上传完成的文件不会被复制
+func (s *FileService) CopyFile(userID uint, req *models.CopyFileRequest) (*models.File, error) {
+	return s.copyFile(s.db, userID, req.FileID, req.ParentID)
+}
+
+func (s *FileService) copyFile(db *gorm.DB, userID uint, fileID uint, parentID *uint) (*models.File, error) {
+	var file models.File
+	if err := db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+		return nil, fmt.Errorf("文件不存在")
+	}
+	if file.Status != models.FileStatusCompleted {
+		return nil, fmt.Errorf("文件尚未上传完成")
+	}
+
+	if err := s.checkParentFolder(db, userID, parentID); err != nil {
+		return nil, err
+	}
+	if err := s.checkNotDescendant(db, userID, &file, parentID); err != nil {
+		return nil, err
+	}
+
+	nodes, err := s.loadSubtree(db, userID, file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 副本按各自的大小计入配额
+	var size int64
+	for _, node := range nodes {
+		if !node.IsFolder && node.DeletedAt == nil && node.Status == models.FileStatusCompleted {
+			size += node.FileSize
+		}
+	}
+	unlock := s.lockQuota(userID)
+	defer unlock()
+	if err := s.checkQuota(db, userID, size); err != nil {
+		return nil, err
+	}
+
+	// 与目标目录中的文件重名时自动改名，子节点保留原名
+	rootName := s.uniqueFileName(db, userID, parentID, file.FileName)
+
+	var root *models.File
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 原文件ID -> 副本ID
+		copied := make(map[uint]uint, len(nodes))
+		for i, node := range nodes {
+			if node.DeletedAt != nil || node.Status != models.FileStatusCompleted {
+				continue
+			}
+
+			newParentID := parentID
+			if i > 0 {
+				copiedParentID, ok := copied[*node.ParentID]
+				if !ok {
+					// 父文件夹未被复制
+					continue
+				}
+				newParentID = &copiedParentID
+			}
+
+			newFile := node.File
+			newFile.ID = 0
+			if i == 0 {
+				newFile.FileName = rootName
+			}
+			newFile.ParentID = newParentID
+			newFile.UploadID = ""
+			newFile.CreatedAt = time.Time{}
+			newFile.UpdatedAt = time.Time{}
+			if err := tx.Create(&newFile).Error; err != nil {
+				return fmt.Errorf("复制文件失败: %v", err)
+			}
+			copied[node.ID] = newFile.ID
+
+			if i == 0 {
+				root = &newFile
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"go-auth-server/models"
+)
+
+func createTestFolder(t *testing.T, service *FileService, name string, parentID *uint) *models.File {
+	t.Helper()
+
+	folder, err := service.CreateFolder(1, &models.CreateFolderRequest{FolderName: name, ParentID: parentID})
+	if err != nil {
+		t.Fatalf("创建文件夹 %s 失败: %v", name, err)
+	}
+	return folder
+}
+
+// 文件夹不能移动或复制到自身及其子孙文件夹中，移动到其他位置不受影响
+func TestMoveFolderIntoDescendant(t *testing.T) {
+	service, _ := newTestFileService(t)
+
+	root := createTestFolder(t, service, "root", nil)
+	child := createTestFolder(t, service, "child", &root.ID)
+	grandchild := createTestFolder(t, service, "grandchild", &child.ID)
+	other := createTestFolder(t, service, "other", nil)
+
+	for _, target := range []*models.File{root, child, grandchild} {
+		err := service.MoveFile(1, &models.MoveFileRequest{FileID: root.ID, ParentID: &target.ID})
+		if err == nil || !strings.Contains(err.Error(), "自身或其子文件夹") {
+			t.Errorf("移动到 %s 应被拒绝，实际错误: %v", target.FileName, err)
+		}
+		if _, err := service.CopyFile(1, &models.CopyFileRequest{FileID: root.ID, ParentID: &target.ID}); err == nil {
+			t.Errorf("复制到 %s 应被拒绝", target.FileName)
+		}
+	}
+
+	// 批量移动同样检查
+	result, err := service.BatchMoveFiles(1, &models.BatchTransferRequest{FileIDs: []uint{root.ID}, ParentID: &grandchild.ID})
+	if err == nil && (result == nil || result.Failed == 0) {
+		t.Errorf("批量移动到子孙文件夹应被拒绝")
+	}
+
+	var stored models.File
+	service.db.First(&stored, root.ID)
+	if stored.ParentID != nil {
+		t.Fatalf("被拒绝的移动不应修改父目录，实际为 %d", *stored.ParentID)
+	}
+
+	if err := service.MoveFile(1, &models.MoveFileRequest{FileID: child.ID, ParentID: &other.ID}); err != nil {
+		t.Fatalf("移动到其他文件夹失败: %v", err)
+	}
+	// 子文件夹移走后，原来的父文件夹可以移动到它下面
+	if err := service.MoveFile(1, &models.MoveFileRequest{FileID: root.ID, ParentID: &grandchild.ID}); err != nil {
+		t.Fatalf("移动到不相关的文件夹失败: %v", err)
+	}
+}
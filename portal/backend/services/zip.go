@@ -0,0 +1,148 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"go-auth-server/models"
+)
+
+// 待打包下载的文件集合
+type ZipArchive struct {
+	Name    string // 下载时的文件名
+	entries []zipEntry
+}
+
+type zipEntry struct {
+	name     string       // ZIP中的路径，文件夹以"/"结尾
+	file     *models.File // 文件夹为nil
+	modified time.Time
+}
+
+// 收集要打包下载的文件，文件夹会包含其下所有已上传完成的文件并保留目录结构
+func (s *FileService) PrepareZipDownload(userID uint, req *models.ZipDownloadRequest) (*ZipArchive, error) {
+	archive := &ZipArchive{Name: "download.zip"}
+	used := make(map[string]bool)
+
+	for _, fileID := range req.FileIDs {
+		var file models.File
+		if err := s.db.Where("id = ? AND user_id = ? AND deleted_at IS NULL", fileID, userID).First(&file).Error; err != nil {
+			return nil, fmt.Errorf("文件 %d 不存在", fileID)
+		}
+
+		if !file.IsFolder {
+			if file.Status != models.FileStatusCompleted {
+				return nil, fmt.Errorf("文件 %s 尚未上传完成", file.FileName)
+			}
+			archive.entries = append(archive.entries, zipEntry{name: uniqueEntryName(used, "", file.OriginalName), file: &file})
+			continue
+		}
+
+		if len(req.FileIDs) == 1 {
+			archive.Name = file.OriginalName + ".zip"
+		}
+
+		nodes, err := s.loadSubtree(s.db, userID, file.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// 文件夹ID -> ZIP中的目录
+		dirs := make(map[uint]string, len(nodes))
+		for i := range nodes {
+			node := &nodes[i]
+			if node.DeletedAt != nil {
+				continue
+			}
+
+			dir := ""
+			if i > 0 {
+				parentDir, ok := dirs[*node.ParentID]
+				if !ok {
+					continue
+				}
+				dir = parentDir
+			}
+
+			if node.IsFolder {
+				name := uniqueEntryName(used, dir, node.OriginalName) + "/"
+				dirs[node.ID] = name
+				archive.entries = append(archive.entries, zipEntry{name: name, modified: node.UpdatedAt})
+			} else if node.Status == models.FileStatusCompleted {
+				archive.entries = append(archive.entries, zipEntry{name: uniqueEntryName(used, dir, node.OriginalName), file: &node.File})
+			}
+		}
+	}
+
+	return archive, nil
+}
+
+// 生成目录中不重复的条目名，同名时追加序号
+func uniqueEntryName(used map[string]bool, dir string, name string) string {
+	// 去掉名称中的路径分隔符，避免生成额外的目录层级
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	entry := dir + name
+	for i := 1; used[entry] || used[entry+"/"]; i++ {
+		entry = fmt.Sprintf("%s%s (%d)%s", dir, base, i, ext)
+	}
+	used[entry] = true
+	return entry
+}
+
+// 将文件逐个从存储后端读取并写入ZIP流，不在内存或磁盘中缓存整个文件
+func (s *FileService) WriteZip(w io.Writer, archive *ZipArchive) error {
+	zw := zip.NewWriter(w)
+
+	for _, entry := range archive.entries {
+		if entry.file == nil {
+			if _, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Modified: entry.modified}); err != nil {
+				return fmt.Errorf("写入目录失败: %v", err)
+			}
+			continue
+		}
+
+		if err := s.writeZipEntry(zw, entry); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func (s *FileService) writeZipEntry(zw *zip.Writer, entry zipEntry) error {
+	storage, err := s.storageFor(entry.file)
+	if err != nil {
+		return err
+	}
+
+	reader, err := storage.OpenFile(entry.file)
+	if err != nil {
+		return fmt.Errorf("打开文件 %s 失败: %v", entry.name, err)
+	}
+	defer reader.Close()
+
+	writer, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     entry.name,
+		Method:   zip.Deflate,
+		Modified: entry.file.UpdatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("写入文件 %s 失败: %v", entry.name, err)
+	}
+
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("写入文件 %s 失败: %v", entry.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 事件流票据的有效期，票据只用于建立一次连接
+const StreamTicketTTL = 30 * time.Second
+
+type streamTicket struct {
+	claims  Claims
+	expires time.Time
+}
+
+var (
+	streamTicketsMu sync.Mutex
+	streamTickets   = map[string]streamTicket{}
+)
+
+// 签发一次性的事件流票据。EventSource无法设置请求头，票据代替JWT放在查询参数中，
+// 即使URL被访问日志记录也已失效
+func IssueStreamTicket(userID uint, username, role string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(buf)
+
+	now := time.Now()
+	streamTicketsMu.Lock()
+	defer streamTicketsMu.Unlock()
+	for key, t := range streamTickets {
+		if now.After(t.expires) {
+			delete(streamTickets, key)
+		}
+	}
+	streamTickets[ticket] = streamTicket{
+		claims:  Claims{UserID: userID, Username: username, Role: role},
+		expires: now.Add(StreamTicketTTL),
+	}
+	return ticket, nil
+}
+
+// 兑换事件流票据，无论是否过期票据都只能使用一次
+func RedeemStreamTicket(ticket string) (*Claims, error) {
+	streamTicketsMu.Lock()
+	t, ok := streamTickets[ticket]
+	delete(streamTickets, ticket)
+	streamTicketsMu.Unlock()
+
+	if !ok || time.Now().After(t.expires) {
+		return nil, errors.New("invalid ticket")
+	}
+	return &t.claims, nil
+}